@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/builder"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+)
+
+const usage = `usage: coupons [-env config.yaml] <command> [arguments]
+
+Offline commands read the coupon files of the config, or the files given as
+arguments, and do not need Redis:
+  validate [files...]                              check every line against couponConfig.validation
+  compute [-threshold n] [-out file] [files...]    compute the valid set across the files and print its stats
+  lookup [-threshold n] <code> [files...]          tell whether a code would be accepted, and by which files
+
+Commands against Redis:
+  push [-threshold n] [files...]   load the files into a new coupon dataset version and activate it
+  versions                         show the active coupon dataset version and the retained ones
+  rollback                         activate the previous coupon dataset version
+  revoke [-reason text] <code>     add a coupon code to the revocation list
+  reinstate <code>                 remove a coupon code from the revocation list
+  revocations                      list the revoked coupon codes, most recent first
+  compact                          rebuild the active dataset without the revoked codes
+`
+
+func main() {
+	var envPath string
+	flag.StringVar(&envPath, "env", "config/config.yaml", "Path to environment config file")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	appBuilder := builder.NewAppBuilder(ctx)
+
+	if err := appBuilder.LoadConfig(envPath); err != nil {
+		log.Fatalf("failed to load config (%s): %+v", envPath, err)
+	}
+
+	logger, err := appBuilder.SetLogger()
+	if err != nil {
+		log.Fatalf("failed to initialize logger: %+v", err)
+	}
+	defer logger.Close()
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "validate":
+		useFiles(appBuilder, args, 0)
+		report, err := appBuilder.ValidateCouponFiles()
+		if err != nil {
+			logger.Error("coupon validation failed", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(report)
+		if report.Invalid > 0 {
+			os.Exit(1)
+		}
+		return
+	case "compute":
+		computeFlags := flag.NewFlagSet("compute", flag.ExitOnError)
+		threshold := computeFlags.Int("threshold", 0, "Number of files a code must appear in, instead of couponConfig.threshold")
+		out := computeFlags.String("out", "", "File the valid codes are written to, one per line")
+		_ = computeFlags.Parse(args)
+
+		useFiles(appBuilder, computeFlags.Args(), *threshold)
+		report, err := compute(appBuilder, *out)
+		if err != nil {
+			logger.Error("coupon computation failed", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(report)
+		return
+	case "lookup":
+		lookupFlags := flag.NewFlagSet("lookup", flag.ExitOnError)
+		threshold := lookupFlags.Int("threshold", 0, "Number of files a code must appear in, instead of couponConfig.threshold")
+		_ = lookupFlags.Parse(args)
+		if lookupFlags.NArg() < 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		useFiles(appBuilder, lookupFlags.Args()[1:], *threshold)
+		lookup, err := appBuilder.LookupCoupon(utils.Sanitize(lookupFlags.Arg(0)))
+		if err != nil {
+			logger.Error("coupon lookup failed", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(lookup)
+		if !lookup.Accepted {
+			os.Exit(1)
+		}
+		return
+	case "push", "versions", "rollback", "revoke", "reinstate", "revocations", "compact":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := appBuilder.SetRedisClientrepository(); err != nil {
+		logger.Error("failed to initialize redis", zap.Error(err))
+		os.Exit(1)
+	}
+
+	switch command {
+	case "push":
+		pushFlags := flag.NewFlagSet("push", flag.ExitOnError)
+		threshold := pushFlags.Int("threshold", 0, "Number of files a code must appear in, instead of couponConfig.threshold")
+		_ = pushFlags.Parse(args)
+
+		useFiles(appBuilder, pushFlags.Args(), *threshold)
+		appBuilder.SetReadiness()
+		appBuilder.SetCouponService()
+		if err := appBuilder.ProcessCouponData(); err != nil {
+			logger.Error("coupon push failed", zap.Error(err))
+			os.Exit(1)
+		}
+
+		dataset, err := appBuilder.CouponDataset()
+		if err != nil {
+			logger.Error("failed to read coupon dataset", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(dataset)
+	case "versions":
+		dataset, err := appBuilder.CouponDataset()
+		if err != nil {
+			logger.Error("failed to read coupon dataset", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(dataset)
+	case "rollback":
+		dataset, err := appBuilder.RollbackCoupons()
+		if err != nil {
+			logger.Error("coupon rollback failed", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(dataset)
+	case "revoke":
+		revokeFlags := flag.NewFlagSet("revoke", flag.ExitOnError)
+		reason := revokeFlags.String("reason", "", "Reason recorded with the revocation")
+		_ = revokeFlags.Parse(args)
+
+		code := couponArg(appBuilder.GetConfig().CouponConfig.Validation, revokeFlags.Args())
+		revocation, err := appBuilder.SetCouponService().Revoke(ctx, code, strings.TrimSpace(*reason), revokedBy())
+		if err != nil {
+			logger.Error("coupon revocation failed", zap.String("coupon", code), zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(revocation)
+	case "reinstate":
+		code := couponArg(appBuilder.GetConfig().CouponConfig.Validation, args)
+		revocation, err := appBuilder.SetCouponService().Reinstate(ctx, code)
+		if err != nil {
+			if errors.Is(err, utils.ErrNoData) {
+				fmt.Fprintf(os.Stderr, "coupon %s is not revoked\n", code)
+				os.Exit(1)
+			}
+			logger.Error("coupon reinstatement failed", zap.String("coupon", code), zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(revocation)
+	case "revocations":
+		revocations, err := appBuilder.SetCouponService().Revocations(ctx)
+		if err != nil {
+			logger.Error("failed to list revocations", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(revocations)
+	case "compact":
+		appBuilder.SetCouponService()
+		result, err := appBuilder.CompactCoupons()
+		if err != nil {
+			logger.Error("coupon compaction failed", zap.Error(err))
+			os.Exit(1)
+		}
+		printJSON(result)
+	}
+}
+
+// couponBuilder is the part of the app builder the offline commands use.
+type couponBuilder interface {
+	UseCouponFiles(paths []string, threshold int) error
+	ComputeCoupons(sink func(codes []string) error) (*models.CouponIngestionReport, error)
+}
+
+// useFiles replaces the coupon files of the config with the files given on the
+// command line, if any, and the threshold with threshold when it is set.
+func useFiles(appBuilder couponBuilder, files []string, threshold int) {
+	if err := appBuilder.UseCouponFiles(files, threshold); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+// compute computes the valid coupon set, writing the codes to out when set.
+func compute(appBuilder couponBuilder, out string) (*models.CouponIngestionReport, error) {
+	if out == "" {
+		return appBuilder.ComputeCoupons(nil)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	report, err := appBuilder.ComputeCoupons(func(codes []string) error {
+		for _, code := range codes {
+			if _, err := w.WriteString(code + "\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if err := w.Flush(); err != nil {
+		return report, err
+	}
+	return report, f.Close()
+}
+
+// couponArg returns the single coupon code of args, exiting when it is
+// missing or malformed.
+func couponArg(validation *models.CouponValidator, args []string) string {
+	if len(args) != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	code := utils.NormalizeCode(utils.Sanitize(args[0]), validation)
+	if !utils.ValidateCode(code, validation) {
+		fmt.Fprintf(os.Stderr, "invalid coupon code %q\n", args[0])
+		os.Exit(2)
+	}
+	return code
+}
+
+// revokedBy identifies revocations made from the command line.
+func revokedBy() string {
+	if current, err := user.Current(); err == nil {
+		return "cli:" + current.Username
+	}
+	return "cli"
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("failed to encode output: %+v", err)
+	}
+}
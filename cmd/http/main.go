@@ -46,13 +46,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := appBuilder.ProcessCouponData(); err != nil {
-		logger.Error("coupon data procesiong error", zap.Error(err))
+	appBuilder.SetReadiness()
+
+	if err := appBuilder.SetServices(); err != nil {
+		logger.Error("failed to set services", zap.Error(err))
 		os.Exit(1)
 	}
 
-	appBuilder.SetServices()
-
 	if err := appBuilder.SetHandlers(); err != nil {
 		logger.Error("failed to set handlers", zap.Error(err))
 		os.Exit(1)
@@ -69,6 +69,18 @@ func main() {
 		}
 	}()
 
+	// Coupons load in the background, retried until they succeed; orders
+	// carrying a coupon follow couponConfig.notReadyPolicy until /readyz
+	// reports couponsReady. Periodic compaction, when enabled, starts once the
+	// load is done.
+	go func() {
+		if err := appBuilder.LoadCoupons(); err != nil {
+			logger.Error("coupon data procesiong error", zap.Error(err))
+			return
+		}
+		appBuilder.RunCouponCompaction()
+	}()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
@@ -2,13 +2,16 @@ package builder
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/services"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/egress/cache"
@@ -30,6 +33,11 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	defaultRetryBackoff    = 5 * time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+)
+
 type appBuilder struct {
 	ctx context.Context
 
@@ -38,15 +46,21 @@ type appBuilder struct {
 
 	dbClient *gorm.DB
 
+	couponLoad *couponLoad
+
 	handler fasthttp.RequestHandler
 	server  *fasthttp.Server
 
-	orderServicePorts   ingressPorts.OrderServicePorts
-	productServicePorts ingressPorts.ProductServicePorts
+	readinessServicePorts   ingressPorts.ReadinessServicePorts
+	couponServicePorts      ingressPorts.CouponServicePorts
+	couponAdminServicePorts ingressPorts.CouponAdminServicePorts
+	orderServicePorts       ingressPorts.OrderServicePorts
+	productServicePorts     ingressPorts.ProductServicePorts
 
-	cacheRepository   egressPorts.CacheRepository
-	orderRepository   egressPorts.OrderRepository
-	productRepository egressPorts.ProductRepository
+	cacheRepository      egressPorts.CacheRepository
+	orderRepository      egressPorts.OrderRepository
+	productRepository    egressPorts.ProductRepository
+	couponRuleRepository egressPorts.CouponRuleRepository
 }
 
 func NewAppBuilder(ctx context.Context) *appBuilder {
@@ -122,26 +136,65 @@ func (a *appBuilder) SetRedisClientrepository() error {
 	}
 
 	a.cacheRepository = cacheRepository.NewRepository(redisClient)
+	if a.config.CouponConfig.Bloom.Backend == constants.BloomNative {
+		a.cacheRepository = cacheRepository.NewNativeBloomRepository(a.config.CouponConfig.Bloom, a.cacheRepository)
+	}
 
 	a.logger.Info("Redis initialized successfully", zap.Int64("duration(Micro Sec)", time.Since(start).Microseconds()))
 	return err
 }
 
+func (a *appBuilder) SetReadiness() {
+	a.readinessServicePorts = services.NewReadinessService(a.config, a.logger)
+}
+
+// ProcessCouponData loads the coupon files into Redis, reporting progress to
+// the readiness service. It is safe to run in the background once the server
+// is listening.
 func (a *appBuilder) ProcessCouponData() error {
 	start := time.Now()
 
 	a.logger.Info("Initializing ProcessCouponData")
+	a.readinessServicePorts.StartCouponLoad(len(a.config.CouponConfig.Files))
 	if err := a.processCouponData(); err != nil {
-		if !a.config.CouponConfig.IgnoreUnzipErrors {
+		if !a.config.CouponConfig.IgnoreUnzipErrors || errors.Is(err, errLeaseLost) {
+			a.readinessServicePorts.FinishCouponLoad(err)
 			return err
 		}
 	}
+	a.readinessServicePorts.FinishCouponLoad(nil)
 
 	a.logger.Info("Coupon data initialized successfully", zap.Int64("duration(Micro Sec)", time.Since(start).Microseconds()))
 
 	return nil
 }
 
+// LoadCoupons runs ProcessCouponData until a load succeeds, waiting
+// ingestion.retryBackoff after a failure and doubling the wait after each
+// further one, up to ingestion.maxRetryBackoff. It only gives up when the app
+// context ends.
+func (a *appBuilder) LoadCoupons() error {
+	cfg := a.config.CouponConfig.Ingestion
+	backoff := cmp.Or(cfg.RetryBackoff, defaultRetryBackoff)
+	maxBackoff := max(cmp.Or(cfg.MaxRetryBackoff, defaultMaxRetryBackoff), backoff)
+
+	for {
+		err := a.ProcessCouponData()
+		if err == nil {
+			return nil
+		}
+
+		a.logger.Error("coupon load failed, retrying", zap.Error(err), zap.Duration("backoff", backoff))
+		a.readinessServicePorts.RetryCouponLoad(time.Now().Add(backoff))
+		select {
+		case <-a.ctx.Done():
+			return a.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
 func (a *appBuilder) SetDatabaseRepository() error {
 	start := time.Now()
 
@@ -153,6 +206,7 @@ func (a *appBuilder) SetDatabaseRepository() error {
 	a.dbClient = dbClient
 	a.orderRepository = databaseRepository.NewOrderRepository(dbClient)
 	a.productRepository = databaseRepository.NewProductRepository(dbClient)
+	a.couponRuleRepository = databaseRepository.NewCouponRuleRepository(dbClient)
 
 	a.logger.Info("repository initialized successfully", zap.Int64("duration(Micro Sec)", time.Since(start).Microseconds()))
 	return nil
@@ -162,13 +216,25 @@ func (a *appBuilder) SetServices() error {
 	start := time.Now()
 	a.logger.Info("Initializing services", zap.String("component", "app_builder"), zap.String("step", "SetServices"))
 
-	a.orderServicePorts = services.NewOrderService(a.config, a.logger, a.orderRepository, a.cacheRepository, a.productRepository)
+	if err := a.SetCouponService().LoadRules(a.ctx); err != nil {
+		return err
+	}
+	a.readinessServicePorts.SetCouponStats(a.couponServicePorts.Stats)
+	a.couponAdminServicePorts = services.NewCouponAdminService(a.config, a.logger, a.cacheRepository, a.couponServicePorts)
+	a.orderServicePorts = services.NewOrderService(a.config, a.logger, a.orderRepository, a.productRepository, a.cacheRepository, a.couponServicePorts, a.readinessServicePorts)
 	a.productServicePorts = services.NewProductService(a.config, a.logger, a.productRepository)
 
 	a.logger.Info("Services initialized successfully", zap.Int64("duration(Micro Sec)", time.Since(start).Microseconds()))
 	return nil
 }
 
+// SetCouponService wires the coupon service alone, for tools that manage the
+// coupon datasets in Redis without the database or the HTTP server.
+func (a *appBuilder) SetCouponService() ingressPorts.CouponServicePorts {
+	a.couponServicePorts = services.NewCouponService(a.config, a.logger, a.cacheRepository, a.couponRuleRepository)
+	return a.couponServicePorts
+}
+
 func (a *appBuilder) SetHandlers() error {
 	start := time.Now()
 	a.logger.Info("Initializing handlers", zap.String("component", "app_builder"), zap.String("step", "SetHandlers"))
@@ -178,6 +244,8 @@ func (a *appBuilder) SetHandlers() error {
 	routes, handlerObj := handler.NewHandler(a.config, a.logger, middlewarePorts)
 	handlerObj.SetProductHandler(a.productServicePorts)
 	handlerObj.SetOrderHandler(a.orderServicePorts)
+	handlerObj.SetReadinessHandler(a.readinessServicePorts)
+	handlerObj.SetCouponHandler(a.couponServicePorts, a.couponAdminServicePorts)
 
 	a.handler = routes
 	a.logger.Info("Handlers initialized successfully",
@@ -196,5 +264,11 @@ func (a *appBuilder) Build() (*fasthttp.Server, *models.App) {
 		)
 	}
 	a.server.Handler = handler
+	// Bodies are handed over as streams so coupon uploads need not fit in
+	// memory; the other routes buffer them through the LimitBody middleware,
+	// and multipart forms are not spooled to disk ahead of the handler.
+	a.server.StreamRequestBody = true
+	a.server.DisablePreParseMultipartForm = true
+	a.server.MaxRequestBodySize = a.config.App.Server.BodyLimit()
 	return a.server, a.config.App
 }
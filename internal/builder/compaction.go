@@ -0,0 +1,196 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+)
+
+// revokedCodes reads the revocation list into a set.
+func (b *appBuilder) revokedCodes(ctx context.Context) (map[string]struct{}, error) {
+	codes, err := b.cacheRepository.HKeys(ctx, b.config.CouponConfig.RevokedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revoked coupon codes: %w", err)
+	}
+
+	revoked := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		revoked[code] = struct{}{}
+	}
+	return revoked, nil
+}
+
+// withoutRevoked returns codes minus the revoked ones. codes is returned as
+// is when nothing is revoked, and is never modified.
+func withoutRevoked(codes []string, revoked map[string]struct{}) []string {
+	if len(revoked) == 0 {
+		return codes
+	}
+
+	kept := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if _, ok := revoked[code]; !ok {
+			kept = append(kept, code)
+		}
+	}
+	return kept
+}
+
+// CompactCoupons rebuilds the active dataset without its revoked codes into a
+// new version and activates it. It does nothing when none of the revoked codes
+// is in the active dataset, and fails while a coupon load holds the ingestion
+// lease.
+func (b *appBuilder) CompactCoupons() (*models.CouponCompactionResult, error) {
+	lease, leaseCtx, err := b.tryCouponLease(b.ctx, leaseHolder())
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire coupon ingestion lease: %w", err)
+	}
+	if lease == nil {
+		return nil, errors.New("a coupon load is in progress, retry once it completes")
+	}
+	defer b.releaseCouponLease(lease)
+
+	active, err := b.readActiveDataset(leaseCtx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return nil, errors.New("no active coupon dataset")
+		}
+		return nil, err
+	}
+
+	revoked, err := b.revokedCodes(leaseCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, activeExactKey := b.config.CouponConfig.DatasetKeys(active.Version)
+	var dropped int64
+	for code := range revoked {
+		member, err := b.cacheRepository.SIsMember(leaseCtx, activeExactKey, code)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			dropped++
+		}
+	}
+
+	result := &models.CouponCompactionResult{From: active.Version, To: active.Version}
+	total, err := b.cacheRepository.SCard(leaseCtx, activeExactKey)
+	if err != nil {
+		return nil, err
+	}
+	if dropped == 0 {
+		result.Codes = total
+		b.logger.Info("coupon dataset has no revoked codes, compaction skipped", zap.Int64("version", active.Version))
+		return result, nil
+	}
+
+	version, err := b.cacheRepository.Incr(leaseCtx, b.config.CouponConfig.Versions.ActiveKey+":seq")
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate coupon dataset version: %w", err)
+	}
+	b.logger.Info("compacting coupon dataset", zap.Int64("from", active.Version), zap.Int64("version", version), zap.Int64("revoked", dropped))
+
+	if err := b.copyWithoutRevoked(leaseCtx, lease, activeExactKey, version, total-dropped, revoked); err != nil {
+		b.deleteVersions(b.ctx, version)
+		if errors.Is(context.Cause(leaseCtx), errLeaseLost) {
+			return nil, errLeaseLost
+		}
+		return nil, err
+	}
+	if err := b.activateVersion(leaseCtx, lease, version); err != nil {
+		b.deleteVersions(b.ctx, version)
+		return nil, err
+	}
+
+	_, exactKey := b.config.CouponConfig.DatasetKeys(version)
+	if result.Codes, err = b.cacheRepository.SCard(leaseCtx, exactKey); err != nil {
+		return nil, err
+	}
+	result.To = version
+	result.Dropped = dropped
+
+	b.logger.Info("coupon dataset compacted", zap.Int64("from", result.From), zap.Int64("to", result.To), zap.Int64("codes", result.Codes), zap.Int64("dropped", result.Dropped))
+	return result, nil
+}
+
+// copyWithoutRevoked copies the exact set at exactKey, minus the revoked
+// codes, into the Bloom filter and exact set of version while lease is held.
+// The codes added through the admin API are merged in after the scan, as the
+// full load does, so that one added to the active version while the copy ran
+// is not lost when version is activated.
+func (b *appBuilder) copyWithoutRevoked(ctx context.Context, lease *couponLease, exactKey string, version, kept int64, revoked map[string]struct{}) error {
+	if err := b.reserveBloom(ctx, version, kept); err != nil {
+		return err
+	}
+
+	batch := utils.NewBatch(b.config.CouponConfig.BatchSize, func(codes []string) error {
+		return b.fencedAddCodes(ctx, lease, version, withoutRevoked(codes, revoked))
+	})
+
+	var cursor uint64
+	for {
+		members, next, err := b.cacheRepository.SScan(ctx, exactKey, cursor, int64(b.config.CouponConfig.BatchSize))
+		if err != nil {
+			return err
+		}
+		for _, code := range members {
+			if err := batch.Add(code); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	adminCodes, err := b.cacheRepository.SMembers(ctx, b.config.CouponConfig.AdminCodesKey)
+	if err != nil {
+		return fmt.Errorf("failed to read admin coupon codes: %w", err)
+	}
+	for _, code := range adminCodes {
+		if err := batch.Add(code); err != nil {
+			return err
+		}
+	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+
+	bloomKey, _ := b.config.CouponConfig.DatasetKeys(version)
+	if err := b.cacheRepository.BFSave(ctx, bloomKey); err != nil {
+		return fmt.Errorf("failed to save bloom filter: %w", err)
+	}
+	b.logBloomInfo(ctx, version)
+	return nil
+}
+
+// RunCouponCompaction compacts the active dataset every compaction interval
+// until the builder context is done. It returns immediately when compaction is
+// disabled.
+func (b *appBuilder) RunCouponCompaction() {
+	cfg := b.config.CouponConfig.Compaction
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := b.CompactCoupons(); err != nil {
+				b.logger.Warn("coupon compaction failed", zap.Error(err))
+			}
+		}
+	}
+}
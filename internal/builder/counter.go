@@ -0,0 +1,79 @@
+package builder
+
+import "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+
+type codeState struct {
+	weight   int32
+	required int32
+	lastFile int32
+	accepted bool
+}
+
+// couponCounter decides which codes appear in enough coupon files. A code is
+// accepted once the summed weight of the files it appears in reaches the
+// threshold and it has been seen in every required file. Codes repeated within
+// a file count once, provided each file's codes are added contiguously.
+type couponCounter struct {
+	weights       []int32
+	required      []bool
+	requiredFiles int32
+	threshold     int32
+
+	codes map[string]codeState
+
+	// accepted and duplicates[i], the repeats of a code within file i, feed
+	// the ingestion report; the distinct codes are len(codes).
+	accepted   int64
+	duplicates []int64
+}
+
+func newCouponCounter(files []models.CouponFile, threshold int) *couponCounter {
+	c := &couponCounter{
+		weights:    make([]int32, len(files)),
+		required:   make([]bool, len(files)),
+		threshold:  int32(threshold),
+		codes:      make(map[string]codeState),
+		duplicates: make([]int64, len(files)),
+	}
+
+	for i, file := range files {
+		c.weights[i] = int32(file.EffectiveWeight())
+		c.required[i] = file.Required
+		if file.Required {
+			c.requiredFiles++
+		}
+	}
+
+	return c
+}
+
+// Add records that code appears in file fileIdx and reports whether this
+// occurrence is the one that makes the code valid.
+func (c *couponCounter) Add(fileIdx int, code string) bool {
+	state, ok := c.codes[code]
+	if !ok {
+		state = codeState{lastFile: -1}
+	}
+
+	if state.lastFile == int32(fileIdx) {
+		c.duplicates[fileIdx]++
+		return false
+	}
+	state.lastFile = int32(fileIdx)
+
+	accepted := false
+	if !state.accepted {
+		state.weight += c.weights[fileIdx]
+		if c.required[fileIdx] {
+			state.required++
+		}
+		state.accepted = state.weight >= c.threshold && state.required == c.requiredFiles
+		accepted = state.accepted
+	}
+	if accepted {
+		c.accepted++
+	}
+
+	c.codes[code] = state
+	return accepted
+}
@@ -0,0 +1,101 @@
+package builder
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+)
+
+type counterAdd struct {
+	file int
+	code string
+	want bool
+}
+
+func TestCouponCounter(t *testing.T) {
+	tests := []struct {
+		name           string
+		files          []models.CouponFile
+		threshold      int
+		adds           []counterAdd
+		wantAccepted   int64
+		wantDuplicates []int64
+	}{
+		{
+			name:      "accepted once in K of N files",
+			files:     []models.CouponFile{{Path: "a"}, {Path: "b"}, {Path: "c"}},
+			threshold: 2,
+			adds: []counterAdd{
+				{file: 0, code: "ONLYINA1"},
+				{file: 0, code: "INAANDB1"},
+				{file: 0, code: "INALL001"},
+				{file: 1, code: "INAANDB1", want: true},
+				{file: 1, code: "INALL001", want: true},
+				{file: 2, code: "INALL001"},
+			},
+			wantAccepted:   2,
+			wantDuplicates: []int64{0, 0, 0},
+		},
+		{
+			name:      "file weights add up to the threshold",
+			files:     []models.CouponFile{{Path: "a", Weight: 3}, {Path: "b"}, {Path: "c"}},
+			threshold: 3,
+			adds: []counterAdd{
+				{file: 0, code: "HEAVY001", want: true},
+				{file: 1, code: "LIGHT001"},
+				{file: 2, code: "LIGHT001"},
+				{file: 1, code: "MIXED001"},
+				{file: 2, code: "MIXED001"},
+				{file: 0, code: "MIXED001", want: true},
+			},
+			wantAccepted:   2,
+			wantDuplicates: []int64{0, 0, 0},
+		},
+		{
+			name:      "required files must contain the code",
+			files:     []models.CouponFile{{Path: "a", Required: true}, {Path: "b"}, {Path: "c"}},
+			threshold: 2,
+			adds: []counterAdd{
+				{file: 1, code: "NOTINA01"},
+				{file: 2, code: "NOTINA01"},
+				{file: 1, code: "LATEINA1"},
+				{file: 0, code: "LATEINA1", want: true},
+				{file: 0, code: "ONLYINA1"},
+			},
+			wantAccepted:   1,
+			wantDuplicates: []int64{0, 0, 0},
+		},
+		{
+			name:      "repeats within a file count once",
+			files:     []models.CouponFile{{Path: "a"}, {Path: "b"}},
+			threshold: 2,
+			adds: []counterAdd{
+				{file: 0, code: "REPEAT01"},
+				{file: 0, code: "REPEAT01"},
+				{file: 0, code: "REPEAT01"},
+				{file: 1, code: "REPEAT01", want: true},
+				{file: 1, code: "REPEAT01"},
+			},
+			wantAccepted:   1,
+			wantDuplicates: []int64{2, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := newCouponCounter(tt.files, tt.threshold)
+			for i, add := range tt.adds {
+				if got := counter.Add(add.file, add.code); got != add.want {
+					t.Errorf("add %d (file %d, %s) = %v, want %v", i, add.file, add.code, got, add.want)
+				}
+			}
+			if counter.accepted != tt.wantAccepted {
+				t.Errorf("accepted = %d, want %d", counter.accepted, tt.wantAccepted)
+			}
+			if !slices.Equal(counter.duplicates, tt.wantDuplicates) {
+				t.Errorf("duplicates = %v, want %v", counter.duplicates, tt.wantDuplicates)
+			}
+		})
+	}
+}
@@ -2,13 +2,19 @@ package builder
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -18,28 +24,64 @@ func (b *appBuilder) processCouponData() error {
 	start := time.Now()
 	b.logger.Info("processCouponData starts")
 
-	unzipErrs, fatalErr := b.unzip(b.config.CouponConfig.Files)
-	if fatalErr != nil {
-		if !b.config.CouponConfig.IgnoreUnzipErrors {
-			b.logger.Warn("Unzip execution error ignored", zap.Error(fatalErr))
-			return nil
-		}
-		return fatalErr
+	if err := b.newCouponLoad(); err != nil {
+		return fmt.Errorf("failed to fingerprint coupon files: %w", err)
 	}
 
-	if len(unzipErrs) > 0 {
-		if b.config.CouponConfig.IgnoreUnzipErrors {
-			b.logger.Warn("Unzip error ignored", zap.Errors("unzipErrs", unzipErrs))
-			return nil
-		}
-		return fmt.Errorf("failed to unzip %d coupon files: %v", len(unzipErrs), unzipErrs)
+	lease, leaseCtx, err := b.acquireCouponLease(b.ctx, b.couponLoad.manifest.Fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to acquire coupon ingestion lease: %w", err)
+	}
+	if lease == nil {
+		b.logger.Info("coupons loaded by another replica", zap.String("fingerprint", b.couponLoad.manifest.Fingerprint))
+		return nil
 	}
+	defer b.releaseCouponLease(lease)
+
+	b.couponLoad.ctx = leaseCtx
+	b.couponLoad.lease = lease
+
+	skip, err := b.prepareManifest()
+	if err != nil {
+		return fmt.Errorf("failed to prepare coupon manifest: %w", err)
+	}
+	if skip {
+		return b.markCouponsReady()
+	}
+
+	if b.couponLoad.revoked, err = b.revokedCodes(leaseCtx); err != nil {
+		return err
+	}
+
+	if b.config.CouponConfig.CacheDir != "" {
+		unzipErrs, fatalErr := b.unzip(b.config.CouponConfig.Files)
+		if fatalErr != nil {
+			if b.config.CouponConfig.IgnoreUnzipErrors {
+				b.logger.Warn("Unzip execution error ignored", zap.Error(fatalErr))
+				return nil
+			}
+			return fatalErr
+		}
+
+		if len(unzipErrs) > 0 {
+			if b.config.CouponConfig.IgnoreUnzipErrors {
+				b.logger.Warn("Unzip error ignored", zap.Errors("unzipErrs", unzipErrs))
+				return nil
+			}
+			return fmt.Errorf("failed to unzip %d coupon files: %v", len(unzipErrs), unzipErrs)
+		}
 
-	b.logger.Info("processCouponData unzip completes", zap.Int64("duration(Micro)", time.Since(start).Microseconds()))
+		b.logger.Info("processCouponData unzip completes", zap.Int64("duration(Micro)", time.Since(start).Microseconds()))
+	}
 
 	start = time.Now()
 	b.logger.Info("buildFrequency starts")
-	if err := b.processValidData(); err != nil {
+	err = b.processValidData()
+	b.finishIngestionReport(err)
+	if err != nil {
+		if errors.Is(context.Cause(leaseCtx), errLeaseLost) {
+			return errLeaseLost
+		}
 		if b.config.CouponConfig.IgnoreUnzipErrors {
 			b.logger.Warn("Processing coupon data error ignored", zap.Error(err))
 			return nil
@@ -48,25 +90,48 @@ func (b *appBuilder) processCouponData() error {
 	}
 	b.logger.Info("buildFrequency completes", zap.Int64("duration(ms)", time.Since(start).Milliseconds()))
 
-	return nil
+	return b.completeManifest()
 }
 
-func (b *appBuilder) unzip(files []string) (unzipErrs []error, executionErr error) {
+// sourcePath is the file the coupon codes of file are read from: the
+// decompressed copy in CacheDir when caching is enabled, the file itself
+// otherwise or for an offline load. Copies are named by a hash of the full
+// source path, so files sharing a name in different directories, or differing
+// only by extension, do not overwrite each other.
+func (b *appBuilder) sourcePath(file models.CouponFile) string {
+	cacheDir := b.config.CouponConfig.CacheDir
+	if cacheDir == "" || b.couponLoad.sink != nil {
+		return file.Path
+	}
+
+	path, err := filepath.Abs(file.Path)
+	if err != nil {
+		path = filepath.Clean(file.Path)
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:16])+".txt")
+}
+
+func (b *appBuilder) unzip(files []models.CouponFile) (unzipErrs []error, executionErr error) {
 	var (
 		g    errgroup.Group
 		mu   sync.Mutex
 		errs []error
 	)
 
+	if err := os.MkdirAll(b.config.CouponConfig.CacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
 	maxCpus := utils.Max(1, runtime.NumCPU()/2)
 	g.SetLimit(maxCpus)
 
 	for _, file := range files {
 		f := file
 		g.Go(func() error {
-			if err := utils.Unzip(f, ".gz", ".txt"); err != nil {
+			if err := utils.DecompressToFile(f.Path, b.sourcePath(f)); err != nil {
 				mu.Lock()
-				errs = append(errs, fmt.Errorf("unzip failed for %s: %w", f, err))
+				errs = append(errs, fmt.Errorf("unzip failed for %s: %w", f.Path, err))
 				mu.Unlock()
 			}
 			return nil
@@ -77,121 +142,216 @@ func (b *appBuilder) unzip(files []string) (unzipErrs []error, executionErr erro
 	return errs, executionErr
 }
 
+// newCouponBatch batches the accepted codes of the load into the version being
+// built, or into the sink of an offline load.
+func (b *appBuilder) newCouponBatch() *utils.Batch[string] {
+	if b.couponLoad.sink != nil {
+		return utils.NewBatch(b.config.CouponConfig.BatchSize, b.couponLoad.sink)
+	}
+	return utils.NewBatch(b.config.CouponConfig.BatchSize, b.commitBatch)
+}
+
 func (b *appBuilder) processValidData() error {
-	out := make(chan map[string]bool, len(b.config.CouponConfig.Files))
-	errCh := make(chan error, len(b.config.CouponConfig.Files))
-
-	for _, file := range b.config.CouponConfig.Files {
-		txtFile := strings.TrimSuffix(file, ".gz") + ".txt"
-		go func(f string) {
-			if err := b.parseFile(f, out); err != nil {
-				errCh <- fmt.Errorf("parse error for file %s: %w", f, err)
-			} else {
-				errCh <- nil
+	if b.config.CouponConfig.Ingestion.Mode == constants.IngestSharded {
+		return b.processShardedData()
+	}
+	return b.processInMemoryData()
+}
+
+type fileCodes struct {
+	fileIdx    int
+	codes      []string
+	validLines int64
+	err        error
+}
+
+// processInMemoryData counts every file in memory. Files are consumed in
+// configuration order and codes in file order, so the sequence of batches is
+// the same on every run and an interrupted load can be resumed. The accepted
+// codes are known before the first batch, so the filter is reserved for them.
+func (b *appBuilder) processInMemoryData() error {
+	files := b.config.CouponConfig.Files
+	out := make(chan fileCodes, len(files))
+
+	for i, file := range files {
+		go func(idx int, f string) {
+			codes, validLines, err := b.parseFile(idx, f)
+			if err != nil {
+				err = fmt.Errorf("parse error for file %s: %w", f, err)
+				b.couponLoad.report.fileFailed(idx, err)
 			}
-		}(txtFile)
+			out <- fileCodes{fileIdx: idx, codes: codes, validLines: validLines, err: err}
+		}(i, b.sourcePath(file))
 	}
 
-	freq := make(map[string]int)
-	seenFinal := make(map[string]bool)
-	batch := make([]string, 0, b.config.CouponConfig.BatchSize)
+	counter := newCouponCounter(files, b.config.CouponConfig.Threshold)
+	var accepted []string
 
-	for i := 0; i < len(b.config.CouponConfig.Files); i++ {
-		select {
-		case fileMap := <-out:
-			for code := range fileMap {
-				if seenFinal[code] {
-					continue
-				}
-				freq[code]++
-				if freq[code] == 2 {
-					batch = append(batch, code)
-					seenFinal[code] = true
-					delete(freq, code)
-				}
+	pending := make(map[int]fileCodes, len(files))
+	for next := 0; next < len(files); {
+		parsed := <-out
+		pending[parsed.fileIdx] = parsed
 
-				if len(batch) >= b.config.CouponConfig.BatchSize {
-					if err := b.flushToRedis(batch); err != nil {
-						return err
-					}
-					batch = batch[:0]
+		for ; next < len(files); next++ {
+			parsed, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			if parsed.err != nil {
+				if !b.config.CouponConfig.IgnoreUnzipErrors {
+					return parsed.err
 				}
+				b.logger.Warn("Parse file error ignored", zap.Error(parsed.err))
+				b.couponLoad.partial.Store(true)
+				continue
 			}
-		case err := <-errCh:
-			if err != nil && !b.config.CouponConfig.IgnoreUnzipErrors {
-				return err
-			} else if err != nil {
-				b.logger.Warn("Parse file error ignored", zap.Error(err))
+			b.recordValidLines(parsed.fileIdx, parsed.validLines)
+
+			for _, code := range parsed.codes {
+				if counter.Add(parsed.fileIdx, code) {
+					accepted = append(accepted, code)
+				}
 			}
 		}
 	}
 
-	if len(batch) > 0 {
-		if err := b.flushToRedis(batch); err != nil {
+	b.couponLoad.report.addCounts(counter)
+
+	if err := b.reserveLoad(int64(len(accepted))); err != nil {
+		return err
+	}
+
+	batch := b.newCouponBatch()
+	for _, code := range accepted {
+		if err := batch.Add(code); err != nil {
 			return err
 		}
 	}
-
-	return nil
+	return batch.Flush()
 }
 
-func (b *appBuilder) parseFile(filename string, out chan<- map[string]bool) error {
-	seen := make(map[string]bool)
+// parseFile returns the distinct valid codes of filename, the file at fileIdx,
+// in order of first appearance, along with the number of valid lines.
+func (b *appBuilder) parseFile(fileIdx int, filename string) ([]string, int64, error) {
+	seen := make(map[string]struct{})
+	codes := make([]string, 0)
 
-	f, err := os.Open(filename)
+	f, err := utils.OpenDecompressed(filename)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", filename, err)
+		return nil, 0, fmt.Errorf("failed to open file %s: %w", filename, err)
 	}
 	defer f.Close()
 
 	scanner := bufio.NewScanner(f)
 	scanner.Buffer(make([]byte, 1024*1024), 32*1024*1024)
 
+	tally := b.newFileTally(fileIdx)
+	defer tally.done()
+
+	var validLines int64
 	for scanner.Scan() {
-		code := strings.TrimSpace(scanner.Text())
-		if utils.ValidateCode(code, b.config.CouponConfig.Validation) {
-			seen[code] = true
+		code, ok := tally.check(scanner.Text())
+		if !ok {
+			continue
 		}
+		validLines++
+
+		if _, ok := seen[code]; ok {
+			tally.duplicate()
+			continue
+		}
+		seen[code] = struct{}{}
+		codes = append(codes, code)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("scan error in file %s: %w", filename, err)
+		return nil, 0, fmt.Errorf("scan error in file %s: %w", filename, err)
 	}
 
-	out <- seen
-	return nil
+	return codes, validLines, nil
 }
 
-func (b *appBuilder) flushToRedis(batch []string) error {
-	if len(batch) == 0 {
-		return nil
-	}
+// flushToRedis writes batch to the version being built, leaving out the
+// revoked codes, as long as the load holds the ingestion lease.
+func (b *appBuilder) flushToRedis(ctx context.Context, batch []string) error {
+	return b.fencedAddCodes(ctx, b.couponLoad.lease, b.couponLoad.manifest.Version, withoutRevoked(batch, b.couponLoad.revoked))
+}
 
-	args := make([]interface{}, 0, len(batch)+2)
-	args = append(args, "BF.MADD", b.config.CouponConfig.BloomKey)
-	for _, code := range batch {
-		args = append(args, code)
+// addAdminCodes carries the codes added through the admin API over to the
+// version being built. Adding is idempotent, so it is simply repeated when an
+// interrupted load is resumed.
+func (b *appBuilder) addAdminCodes() error {
+	codes, err := b.cacheRepository.SMembers(b.couponLoad.ctx, b.config.CouponConfig.AdminCodesKey)
+	if err != nil {
+		return fmt.Errorf("failed to read admin coupon codes: %w", err)
 	}
 
-	if err := b.cacheRepository.Do(b.ctx, args); err != nil {
-		b.logger.Info("bloom filter creation", zap.Error(err))
-		if !strings.Contains(err.Error(), "item exists") {
-			return fmt.Errorf("failed to create bloom filter: %w", err)
+	batch := utils.NewBatch(b.config.CouponConfig.BatchSize, func(codes []string) error {
+		return b.flushToRedis(b.couponLoad.ctx, codes)
+	})
+	for _, code := range codes {
+		if err := batch.Add(code); err != nil {
+			return err
 		}
 	}
+	if err := batch.Flush(); err != nil {
+		return err
+	}
 
-	members := make([]interface{}, len(batch))
-	for i, code := range batch {
-		members[i] = code
+	if len(codes) > 0 {
+		b.logger.Info("admin coupon codes added", zap.Int("codes", len(codes)))
 	}
+	return nil
+}
 
-	fmt.Println("\n\n\n ")
-	b.logger.Info("members", zap.Any("members", members))
-	fmt.Println("\n\n\n ")
+// reserveLoad reserves the Bloom filter of the version being built for
+// accepted codes. Offline loads have no filter.
+func (b *appBuilder) reserveLoad(accepted int64) error {
+	if b.couponLoad.sink != nil {
+		return nil
+	}
+	return b.reserveBloom(b.couponLoad.ctx, b.couponLoad.manifest.Version, accepted)
+}
 
-	if err := b.cacheRepository.SAdd(b.ctx, b.config.CouponConfig.ExactSet, members...); err != nil {
-		return fmt.Errorf("redis SADD error: %w", err)
+// reserveBloom sizes the Bloom filter of version for codes accepted codes, or
+// the configured capacity if that is larger.
+func (b *appBuilder) reserveBloom(ctx context.Context, version, codes int64) error {
+	cfg := b.config.CouponConfig.Bloom
+	bloomKey, _ := b.config.CouponConfig.DatasetKeys(version)
+	capacity := max(uint64(codes), cfg.Capacity)
+
+	if err := b.cacheRepository.BFReserve(ctx, bloomKey, cfg.ErrorRate, capacity, cfg.Expansion); err != nil {
+		return fmt.Errorf("failed to reserve bloom filter: %w", err)
 	}
 
+	b.logger.Info("bloom filter reserved",
+		zap.String("key", bloomKey),
+		zap.Int64("codes", codes),
+		zap.Uint64("capacity", capacity),
+		zap.Float64("errorRate", cfg.ErrorRate),
+		zap.Int("expansion", cfg.Expansion),
+	)
 	return nil
 }
+
+// logBloomInfo logs the BF.INFO stats of the Bloom filter of version.
+func (b *appBuilder) logBloomInfo(ctx context.Context, version int64) {
+	bloomKey, _ := b.config.CouponConfig.DatasetKeys(version)
+
+	info, err := b.cacheRepository.BFInfo(ctx, bloomKey)
+	if err != nil {
+		b.logger.Warn("failed to read bloom filter info", zap.String("key", bloomKey), zap.Error(err))
+		return
+	}
+
+	b.logger.Info("bloom filter info",
+		zap.String("key", bloomKey),
+		zap.Int64("capacity", info.Capacity),
+		zap.Int64("size", info.Size),
+		zap.Int64("filters", info.Filters),
+		zap.Int64("itemsInserted", info.ItemsInserted),
+		zap.Int64("expansionRate", info.ExpansionRate),
+	)
+}
@@ -0,0 +1,363 @@
+package builder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultProgressInterval = 1000000
+
+	// bytesPerCode approximates the memory one code costs in the counter map.
+	bytesPerCode = 96
+	// compressedExpansion approximates how much a compressed coupon file grows
+	// once decompressed.
+	compressedExpansion = 4
+
+	minShardBuffer = 4 * 1024
+	maxShardBuffer = 64 * 1024
+
+	// maxOpenShardFiles bounds the shard files open at once across the files
+	// split in parallel. A file needing more shards is split in several passes.
+	maxOpenShardFiles = 512
+)
+
+// processShardedData bounds memory for very large coupon bases. Valid codes are
+// first spilled to temp files sharded by hash, one set of shards per input
+// file; every shard is then counted on its own, so the counter only ever holds
+// the codes of a single shard. At most maxOpenShardFiles shard files are open
+// at once: each input is read once per group of shards that fits the budget.
+func (b *appBuilder) processShardedData() error {
+	cfg := b.config.CouponConfig
+	files := cfg.Files
+	shards := b.shardCount()
+
+	runDir, err := os.MkdirTemp(cfg.Ingestion.TempDir, "coupons-")
+	if err != nil {
+		return fmt.Errorf("failed to create ingestion temp dir: %w", err)
+	}
+	defer os.RemoveAll(runDir)
+
+	parallel := min(utils.Max(1, runtime.NumCPU()/2), len(files))
+	openShards := min(shards, utils.Max(1, maxOpenShardFiles/parallel))
+
+	b.logger.Info("sharded ingestion starts",
+		zap.Int("shards", shards),
+		zap.Int("passes", (shards+openShards-1)/openShards),
+		zap.String("tempDir", runDir),
+	)
+
+	start := time.Now()
+	var g errgroup.Group
+	g.SetLimit(parallel)
+
+	for i, file := range files {
+		idx, src := i, b.sourcePath(file)
+		g.Go(func() error {
+			validLines, err := b.splitFile(runDir, idx, src, shards, openShards)
+			if err != nil {
+				err = fmt.Errorf("split error for file %s: %w", src, err)
+				b.couponLoad.report.fileFailed(idx, err)
+				if !cfg.IgnoreUnzipErrors {
+					return err
+				}
+				b.logger.Warn("Parse file error ignored", zap.Error(err))
+				b.couponLoad.partial.Store(true)
+				return os.RemoveAll(fileShardDir(runDir, idx))
+			}
+			b.recordValidLines(idx, validLines)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	b.logger.Info("sharded ingestion split completes", zap.Int64("duration(ms)", time.Since(start).Milliseconds()))
+
+	// Count the accepted codes first so the filter can be reserved for them;
+	// shards are local files, so reading them twice is cheap next to the
+	// decompression of the inputs.
+	start = time.Now()
+	var accepted int64
+	for shard := 0; shard < shards; shard++ {
+		counter, err := b.mergeShard(runDir, shard, func(string) error { return nil })
+		if err != nil {
+			return err
+		}
+		accepted += counter.accepted
+		b.couponLoad.report.addCounts(counter)
+	}
+	b.logger.Info("sharded ingestion count completes",
+		zap.Int64("accepted", accepted),
+		zap.Int64("duration(ms)", time.Since(start).Milliseconds()),
+	)
+
+	if err := b.reserveLoad(accepted); err != nil {
+		return err
+	}
+
+	start = time.Now()
+	batch := b.newCouponBatch()
+	var merged int64
+
+	for shard := 0; shard < shards; shard++ {
+		counter, err := b.mergeShard(runDir, shard, batch.Add)
+		if err != nil {
+			return err
+		}
+		merged += counter.accepted
+
+		for i := range files {
+			_ = os.Remove(shardPath(runDir, i, shard))
+		}
+
+		b.logger.Info("sharded ingestion progress",
+			zap.Int("shard", shard+1),
+			zap.Int("shards", shards),
+			zap.Int64("shardCodes", counter.accepted),
+			zap.Int64("accepted", merged),
+		)
+	}
+
+	if err := batch.Flush(); err != nil {
+		return err
+	}
+
+	b.logger.Info("sharded ingestion merge completes",
+		zap.Int64("accepted", merged),
+		zap.Int64("duration(ms)", time.Since(start).Milliseconds()),
+	)
+	return nil
+}
+
+// mergeShard counts one shard across every input file and passes each
+// accepted code to fn, returning the counter of the shard. Every shard file
+// of an input is written whole by a single split pass, so the counter gets
+// each input's codes of the shard contiguously, as it requires.
+func (b *appBuilder) mergeShard(runDir string, shard int, fn func(code string) error) (*couponCounter, error) {
+	files := b.config.CouponConfig.Files
+	counter := newCouponCounter(files, b.config.CouponConfig.Threshold)
+
+	for i := range files {
+		path := shardPath(runDir, i, shard)
+		err := readShard(path, func(code string) error {
+			if !counter.Add(i, code) {
+				return nil
+			}
+			return fn(code)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("merge error for shard %s: %w", path, err)
+		}
+	}
+	return counter, nil
+}
+
+// shardCount returns the configured shard count, or estimates one from the
+// input sizes so that a single shard fits in MemoryLimitMB.
+func (b *appBuilder) shardCount() int {
+	cfg := b.config.CouponConfig
+	if cfg.Ingestion.Shards > 0 {
+		return cfg.Ingestion.Shards
+	}
+
+	var estimatedBytes int64
+	for _, file := range cfg.Files {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			continue
+		}
+		size := info.Size()
+		if filepath.Ext(file.Path) != ".txt" {
+			size *= compressedExpansion
+		}
+		estimatedBytes += size
+	}
+
+	estimatedCodes := estimatedBytes / int64(cfg.Validation.MaxLength+1)
+	limit := int64(cfg.Ingestion.MemoryLimitMB) * 1024 * 1024
+
+	shards := int((estimatedCodes*bytesPerCode + limit - 1) / limit)
+	return min(max(shards, 1), 4096)
+}
+
+// splitFile spills the valid codes of filename to its shards, with at most
+// openShards shard files open: every pass reads the whole file and writes the
+// next range of shards. The first pass also tallies and quarantines the lines.
+func (b *appBuilder) splitFile(runDir string, fileIdx int, filename string, shards, openShards int) (int64, error) {
+	dir := fileShardDir(runDir, fileIdx)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return 0, err
+	}
+
+	var valid int64
+	for first := 0; first < shards; first += openShards {
+		last := min(first+openShards, shards)
+
+		var tally *fileTally
+		if first == 0 {
+			tally = b.newFileTally(fileIdx)
+		}
+		passValid, err := b.splitPass(runDir, fileIdx, filename, shards, first, last, tally)
+		if tally != nil {
+			tally.done()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if first == 0 {
+			valid = passValid
+		}
+	}
+
+	b.logger.Info("sharded ingestion split file completes",
+		zap.String("file", filename),
+		zap.Int64("valid", valid),
+	)
+	return valid, nil
+}
+
+// splitPass writes the valid codes of filename that fall in shards [first,
+// last) and returns how many valid lines the file has. Lines are checked by
+// tally when given.
+func (b *appBuilder) splitPass(runDir string, fileIdx int, filename string, shards, first, last int, tally *fileTally) (int64, error) {
+	in, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer in.Close()
+
+	limit := b.config.CouponConfig.Ingestion.MemoryLimitMB * 1024 * 1024
+	bufSize := min(max(limit/(4*len(b.config.CouponConfig.Files)*(last-first)), minShardBuffer), maxShardBuffer)
+
+	outFiles := make([]*os.File, last-first)
+	writers := make([]*bufio.Writer, last-first)
+	defer func() {
+		for _, f := range outFiles {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+
+	for i := range outFiles {
+		f, err := os.Create(shardPath(runDir, fileIdx, first+i))
+		if err != nil {
+			return 0, err
+		}
+		outFiles[i] = f
+		writers[i] = bufio.NewWriterSize(f, bufSize)
+	}
+
+	progressInterval := int64(b.config.CouponConfig.Ingestion.ProgressInterval)
+	if progressInterval <= 0 {
+		progressInterval = defaultProgressInterval
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 1024*1024), 32*1024*1024)
+
+	validation := b.config.CouponConfig.Validation
+	var lines, valid int64
+	for scanner.Scan() {
+		lines++
+		if lines%progressInterval == 0 {
+			b.logger.Info("sharded ingestion split progress",
+				zap.String("file", filename),
+				zap.Int("firstShard", first),
+				zap.Int64("lines", lines),
+				zap.Int64("valid", valid),
+			)
+		}
+
+		var code string
+		if tally != nil {
+			var ok bool
+			if code, ok = tally.check(scanner.Text()); !ok {
+				continue
+			}
+		} else {
+			code = utils.NormalizeCode(scanner.Text(), validation)
+			if utils.CheckCode(code, validation) != "" {
+				continue
+			}
+		}
+		valid++
+
+		shard := shardOf(code, shards)
+		if shard < first || shard >= last {
+			continue
+		}
+		w := writers[shard-first]
+		if _, err := w.WriteString(code); err != nil {
+			return 0, err
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("scan error in file %s: %w", filename, err)
+	}
+
+	for i, w := range writers {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		if err := outFiles[i].Close(); err != nil {
+			return 0, err
+		}
+		outFiles[i] = nil
+	}
+	return valid, nil
+}
+
+func readShard(path string, fn func(code string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := fn(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func fileShardDir(runDir string, fileIdx int) string {
+	return filepath.Join(runDir, fmt.Sprintf("file-%d", fileIdx))
+}
+
+func shardPath(runDir string, fileIdx, shard int) string {
+	return filepath.Join(fileShardDir(runDir, fileIdx), fmt.Sprintf("shard-%d", shard))
+}
+
+// shardOf assigns code to a shard using FNV-1a.
+func shardOf(code string, shards int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(code); i++ {
+		h ^= uint32(code[i])
+		h *= 16777619
+	}
+	return int(h % uint32(shards))
+}
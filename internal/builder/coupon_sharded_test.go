@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/pkg/logger"
+)
+
+// TestShardedCountMatchesInMemory checks that splitting the files into shards,
+// over several passes, hands each file's codes of a shard to the counter
+// contiguously: the accepted codes and the repeats within a file are those of
+// counting the files whole.
+func TestShardedCountMatchesInMemory(t *testing.T) {
+	const (
+		codes  = 300
+		shards = 16
+	)
+
+	dir := t.TempDir()
+	files := []models.CouponFile{
+		{Path: filepath.Join(dir, "a.txt"), Weight: 2},
+		{Path: filepath.Join(dir, "b.txt")},
+		{Path: filepath.Join(dir, "c.txt"), Required: true},
+	}
+
+	// Code i is in file f when bit f of i%8 is set, repeated within the file
+	// when i%5 == 0, and the file lists its codes in reverse on every other
+	// file so the same code sits at different offsets.
+	lines := make([][]string, len(files))
+	for f := range files {
+		for i := range codes {
+			if (i%8)&(1<<f) == 0 {
+				continue
+			}
+			lines[f] = append(lines[f], testCode(i))
+			if i%5 == 0 {
+				lines[f] = append(lines[f], strings.ToLower(testCode(i)))
+			}
+		}
+		if f%2 == 1 {
+			slices.Reverse(lines[f])
+		}
+		if err := os.WriteFile(files[f].Path, []byte(strings.Join(lines[f], "\n")+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log, err := logger.NewLogger(constants.LERROR, constants.ENV_PRODUCTION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &appBuilder{
+		ctx:    context.Background(),
+		logger: log,
+		config: &models.Config{CouponConfig: &models.CouponConfig{
+			Threshold: 3,
+			Files:     files,
+			Validation: &models.CouponValidator{
+				MinLength:         8,
+				MaxLength:         10,
+				AllowedCharacters: constants.Uppercase,
+				Case:              constants.CaseUpper,
+			},
+			Ingestion: &models.CouponIngestion{Mode: constants.IngestSharded, MemoryLimitMB: 1},
+		}},
+	}
+	b.startOfflineLoad("", nil)
+
+	want := newCouponCounter(files, 3)
+	var wantAccepted []string
+	for f := range files {
+		for _, line := range lines[f] {
+			if want.Add(f, strings.ToUpper(line)) {
+				wantAccepted = append(wantAccepted, strings.ToUpper(line))
+			}
+		}
+	}
+
+	runDir := t.TempDir()
+	for f, file := range files {
+		if _, err := b.splitFile(runDir, f, file.Path, shards, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var accepted []string
+	duplicates := make([]int64, len(files))
+	for shard := range shards {
+		counter, err := b.mergeShard(runDir, shard, func(code string) error {
+			accepted = append(accepted, code)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for f, n := range counter.duplicates {
+			duplicates[f] += n
+		}
+	}
+
+	slices.Sort(accepted)
+	slices.Sort(wantAccepted)
+	if len(wantAccepted) == 0 || !slices.Equal(accepted, wantAccepted) {
+		t.Errorf("accepted %d codes, want %d: %v", len(accepted), len(wantAccepted), accepted)
+	}
+	if !slices.Equal(duplicates, want.duplicates) {
+		t.Errorf("duplicates = %v, want %v", duplicates, want.duplicates)
+	}
+}
+
+// testCode returns an uppercase code of 8 letters for i.
+func testCode(i int) string {
+	code := []byte("CODE")
+	for range 4 {
+		code = append(code, byte('A'+i%26))
+		i /= 26
+	}
+	return string(code)
+}
@@ -0,0 +1,157 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+)
+
+// startVersion allocates a fresh dataset version for the current load.
+func (b *appBuilder) startVersion() error {
+	load := b.couponLoad
+	version, err := b.cacheRepository.Incr(load.ctx, b.config.CouponConfig.Versions.ActiveKey+":seq")
+	if err != nil {
+		return fmt.Errorf("failed to allocate coupon dataset version: %w", err)
+	}
+	load.manifest.Version = version
+	b.logger.Info("building coupon dataset version", zap.Int64("version", version))
+	return nil
+}
+
+// discardVersion deletes the keys of an abandoned, never activated version.
+func (b *appBuilder) discardVersion(ctx context.Context, version int64) {
+	active, err := b.readActiveDataset(ctx)
+	if err != nil && !errors.Is(err, utils.ErrNoData) {
+		b.logger.Warn("failed to read active coupon dataset", zap.Error(err))
+		return
+	}
+	if active != nil && (active.Version == version || slices.Contains(active.Previous, version)) {
+		return
+	}
+
+	b.deleteVersions(ctx, version)
+}
+
+// activateVersion atomically points the active key at version, provided lease
+// is still held, and drops the versions that fall out of retention.
+func (b *appBuilder) activateVersion(ctx context.Context, lease *couponLease, version int64) error {
+	cfg := b.config.CouponConfig.Versions
+
+	active, err := b.readActiveDataset(ctx)
+	if err != nil && !errors.Is(err, utils.ErrNoData) {
+		return err
+	}
+
+	next := &models.CouponDataset{Version: version, ActivatedAt: time.Now().UTC()}
+	if active != nil {
+		if active.Version == version {
+			return nil
+		}
+		next.Previous = append([]int64{active.Version}, active.Previous...)
+	}
+
+	var dropped []int64
+	if len(next.Previous) > cfg.Retention {
+		dropped = next.Previous[cfg.Retention:]
+		next.Previous = next.Previous[:cfg.Retention]
+	}
+
+	raw, err := json.Marshal(next)
+	if err != nil {
+		return fmt.Errorf("failed to encode coupon dataset: %w", err)
+	}
+	if err := b.fencedSet(ctx, lease, cfg.ActiveKey, raw); err != nil {
+		return fmt.Errorf("failed to activate coupon dataset: %w", err)
+	}
+
+	b.logger.Info("coupon dataset activated", zap.Int64("version", version), zap.Int64s("retained", next.Previous))
+	b.deleteVersions(ctx, dropped...)
+	return nil
+}
+
+// RollbackCoupons points the active key back at the most recent retained
+// version and deletes the version being rolled back. It fails while a coupon
+// load holds the ingestion lease.
+func (b *appBuilder) RollbackCoupons() (*models.CouponDataset, error) {
+	lease, leaseCtx, err := b.tryCouponLease(b.ctx, leaseHolder())
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil {
+		return nil, errors.New("a coupon load is in progress, retry once it completes")
+	}
+	defer b.releaseCouponLease(lease)
+
+	active, err := b.readActiveDataset(leaseCtx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return nil, errors.New("no active coupon dataset")
+		}
+		return nil, err
+	}
+	if len(active.Previous) == 0 {
+		return nil, fmt.Errorf("no previous coupon dataset to roll back to from version %d", active.Version)
+	}
+
+	next := &models.CouponDataset{
+		Version:     active.Previous[0],
+		Previous:    active.Previous[1:],
+		ActivatedAt: time.Now().UTC(),
+	}
+
+	raw, err := json.Marshal(next)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode coupon dataset: %w", err)
+	}
+
+	if err := b.fencedSet(leaseCtx, lease, b.config.CouponConfig.Versions.ActiveKey, raw); err != nil {
+		return nil, err
+	}
+
+	b.logger.Info("coupon dataset rolled back", zap.Int64("from", active.Version), zap.Int64("to", next.Version))
+	b.deleteVersions(b.ctx, active.Version)
+	return next, nil
+}
+
+// CouponDataset returns the active coupon dataset.
+func (b *appBuilder) CouponDataset() (*models.CouponDataset, error) {
+	return b.readActiveDataset(b.ctx)
+}
+
+func (b *appBuilder) readActiveDataset(ctx context.Context) (*models.CouponDataset, error) {
+	raw, err := b.cacheRepository.Get(ctx, b.config.CouponConfig.Versions.ActiveKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataset models.CouponDataset
+	if err := json.Unmarshal([]byte(raw), &dataset); err != nil {
+		return nil, fmt.Errorf("failed to decode coupon dataset: %w", err)
+	}
+	return &dataset, nil
+}
+
+func (b *appBuilder) deleteVersions(ctx context.Context, versions ...int64) {
+	if len(versions) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, 2*len(versions))
+	for _, version := range versions {
+		bloomKey, exactKey := b.config.CouponConfig.DatasetKeys(version)
+		keys = append(keys, bloomKey, exactKey)
+	}
+
+	if err := b.cacheRepository.Del(ctx, keys...); err != nil {
+		b.logger.Warn("failed to delete coupon dataset versions", zap.Int64s("versions", versions), zap.Error(err))
+		return
+	}
+	b.logger.Info("coupon dataset versions deleted", zap.Int64s("versions", versions))
+}
@@ -0,0 +1,197 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var errLeaseLost = errors.New("coupon ingestion lease lost")
+
+// couponLease is the Redis lease that makes a replica the only one loading
+// coupons. Writes are fenced on both value, the lease holder, and token, which
+// increases with every acquisition, so a holder whose lease expired cannot
+// write even if the lock is taken again under the same value. token is also
+// recorded in the manifest.
+type couponLease struct {
+	value  string
+	token  int64
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// acquireCouponLease blocks until this replica holds the ingestion lease or
+// another replica has marked the dataset identified by fingerprint ready, in
+// which case the returned lease is nil. The returned context is cancelled with
+// errLeaseLost as soon as the lease can no longer be renewed.
+func (b *appBuilder) acquireCouponLease(ctx context.Context, fingerprint string) (*couponLease, context.Context, error) {
+	cfg := b.config.CouponConfig.Lock
+	deadline := time.Now().Add(cfg.WaitTimeout)
+	value := leaseHolder()
+
+	for {
+		ready, err := b.couponsReady(ctx, fingerprint)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ready {
+			return nil, nil, nil
+		}
+
+		lease, leaseCtx, err := b.tryCouponLease(ctx, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lease != nil {
+			return lease, leaseCtx, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("timed out after %s waiting for coupons to be loaded by another replica", cfg.WaitTimeout)
+		}
+
+		b.logger.Info("coupon ingestion running on another replica, waiting", zap.Duration("pollInterval", cfg.PollInterval))
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+}
+
+// tryCouponLease acquires the ingestion lease for value without waiting. The
+// returned lease is nil when another holder has it.
+func (b *appBuilder) tryCouponLease(ctx context.Context, value string) (*couponLease, context.Context, error) {
+	cfg := b.config.CouponConfig.Lock
+
+	acquired, err := b.cacheRepository.AcquireLock(ctx, cfg.Key, value, cfg.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !acquired {
+		return nil, nil, nil
+	}
+
+	token, err := b.cacheRepository.Incr(ctx, cfg.FenceKey())
+	if err != nil {
+		_ = b.cacheRepository.ReleaseLock(ctx, cfg.Key, value)
+		return nil, nil, err
+	}
+
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	lease := &couponLease{
+		value:  value,
+		token:  token,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.renewCouponLease(leaseCtx, lease)
+
+	b.logger.Info("coupon ingestion lease acquired", zap.String("holder", value), zap.Int64("fencingToken", token))
+	return lease, leaseCtx, nil
+}
+
+func (b *appBuilder) renewCouponLease(ctx context.Context, lease *couponLease) {
+	defer close(lease.done)
+
+	cfg := b.config.CouponConfig.Lock
+	ticker := time.NewTicker(cfg.RenewInterval)
+	defer ticker.Stop()
+
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := b.cacheRepository.RenewLock(ctx, cfg.Key, lease.value, cfg.TTL)
+			if err != nil {
+				b.logger.Warn("coupon ingestion lease renewal failed", zap.Error(err))
+				if time.Since(lastRenewed) < cfg.TTL {
+					continue
+				}
+				renewed = false
+			}
+
+			if !renewed {
+				b.logger.Error("coupon ingestion lease lost, aborting load", zap.Int64("fencingToken", lease.token))
+				lease.cancel(errLeaseLost)
+				return
+			}
+			lastRenewed = time.Now()
+		}
+	}
+}
+
+func (b *appBuilder) releaseCouponLease(lease *couponLease) {
+	lease.cancel(nil)
+	<-lease.done
+
+	if err := b.cacheRepository.ReleaseLock(b.ctx, b.config.CouponConfig.Lock.Key, lease.value); err != nil {
+		b.logger.Warn("failed to release coupon ingestion lease", zap.Error(err))
+		return
+	}
+	b.logger.Info("coupon ingestion lease released", zap.Int64("fencingToken", lease.token))
+}
+
+// fence returns what a fenced write checks to be sure lease is still held.
+func (b *appBuilder) fence(lease *couponLease) models.CouponLeaseFence {
+	cfg := b.config.CouponConfig.Lock
+	return models.CouponLeaseFence{
+		LockKey:  cfg.Key,
+		Value:    lease.value,
+		FenceKey: cfg.FenceKey(),
+		Token:    lease.token,
+	}
+}
+
+// fencedSet writes key only while lease is still held.
+func (b *appBuilder) fencedSet(ctx context.Context, lease *couponLease, key string, value interface{}) error {
+	ok, err := b.cacheRepository.SetIfLocked(ctx, b.fence(lease), key, value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		lease.cancel(errLeaseLost)
+		return errLeaseLost
+	}
+	return nil
+}
+
+// fencedAddCodes adds codes to version only while lease is still held.
+func (b *appBuilder) fencedAddCodes(ctx context.Context, lease *couponLease, version int64, codes []string) error {
+	ok, err := b.couponServicePorts.AddCodesIfLocked(ctx, version, b.fence(lease), codes)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		lease.cancel(errLeaseLost)
+		return errLeaseLost
+	}
+	return nil
+}
+
+// couponsReady reports whether the dataset identified by fingerprint has been
+// fully loaded by some replica.
+func (b *appBuilder) couponsReady(ctx context.Context, fingerprint string) (bool, error) {
+	marker, err := b.cacheRepository.Get(ctx, b.config.CouponConfig.Lock.ReadyKey)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return false, nil
+		}
+		return false, err
+	}
+	return marker == fingerprint, nil
+}
+
+func leaseHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), uuid.NewString())
+}
@@ -0,0 +1,273 @@
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// couponLoad tracks the progress of one coupon load against its manifest. All
+// Redis writes of the load use ctx, which is cancelled when lease is lost.
+type couponLoad struct {
+	ctx         context.Context
+	lease       *couponLease
+	manifest    *models.CouponManifest
+	revoked     map[string]struct{}
+	report      *ingestionReport
+	skipBatches int64
+	batchSeq    int64
+	partial     atomic.Bool
+
+	// sink receives the accepted codes of an offline load instead of Redis.
+	sink func(codes []string) error
+}
+
+// newCouponLoad fingerprints the coupon files and starts tracking a new load.
+func (b *appBuilder) newCouponLoad() error {
+	files, err := b.fingerprintFiles()
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := b.datasetFingerprint(files)
+	if err != nil {
+		return err
+	}
+
+	b.couponLoad = &couponLoad{
+		ctx: b.ctx,
+		manifest: &models.CouponManifest{
+			Fingerprint: fingerprint,
+			Files:       files,
+		},
+		report: newIngestionReport(b.config.CouponConfig, fingerprint),
+	}
+	return nil
+}
+
+// prepareManifest compares the current load with the manifest of the previous
+// one. It reports whether the load can be skipped entirely because nothing
+// changed since a completed load.
+func (b *appBuilder) prepareManifest() (bool, error) {
+	load := b.couponLoad
+	fingerprint := load.manifest.Fingerprint
+
+	previous, err := b.readManifest(load.ctx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return false, b.startVersion()
+		}
+		return false, err
+	}
+
+	switch {
+	case previous.Version == 0:
+		b.logger.Info("coupon manifest predates versioned datasets, reloading")
+		return false, b.startVersion()
+	case previous.Fingerprint != fingerprint:
+		b.logger.Info("coupon files changed, reloading", zap.String("previous", previous.Fingerprint), zap.String("current", fingerprint))
+		if !previous.Completed {
+			b.discardVersion(load.ctx, previous.Version)
+		}
+		return false, b.startVersion()
+	}
+
+	if previous.Completed {
+		b.readinessServicePorts.UpdateCouponProgress(previous.CompletedBatches, previous.CodesLoaded)
+		b.logger.Info("coupon files unchanged, skipping load",
+			zap.String("fingerprint", fingerprint),
+			zap.Int64("version", previous.Version),
+			zap.Int64("codesLoaded", previous.CodesLoaded),
+		)
+		return true, nil
+	}
+
+	load.manifest.Version = previous.Version
+	if b.config.CouponConfig.Bloom.Backend == constants.BloomNative {
+		// The in-process filter of the interrupted load is gone; every batch
+		// is added again, which is idempotent for the exact set.
+		b.logger.Info("restarting interrupted coupon load, native bloom filter cannot resume", zap.Int64("version", previous.Version))
+		return false, nil
+	}
+
+	load.manifest.CodesLoaded = previous.CodesLoaded
+	load.manifest.CompletedBatches = previous.CompletedBatches
+	load.skipBatches = previous.CompletedBatches
+	b.readinessServicePorts.UpdateCouponProgress(previous.CompletedBatches, previous.CodesLoaded)
+	b.logger.Info("resuming interrupted coupon load",
+		zap.Int64("version", previous.Version),
+		zap.Int64("completedBatches", previous.CompletedBatches),
+		zap.Int64("previousFencingToken", previous.FencingToken),
+	)
+	return false, nil
+}
+
+// commitBatch flushes batch unless an earlier, interrupted run already did,
+// then checkpoints the manifest.
+func (b *appBuilder) commitBatch(batch []string) error {
+	load := b.couponLoad
+	load.batchSeq++
+	if load.batchSeq <= load.skipBatches {
+		return nil
+	}
+
+	if err := b.flushToRedis(load.ctx, batch); err != nil {
+		return err
+	}
+
+	load.manifest.CompletedBatches = load.batchSeq
+	load.manifest.CodesLoaded += int64(len(batch))
+	b.readinessServicePorts.UpdateCouponProgress(load.manifest.CompletedBatches, load.manifest.CodesLoaded)
+	return b.writeManifest()
+}
+
+// completeManifest activates the dataset built by the load, marks the load
+// completed and publishes the ready marker other replicas are waiting for. The
+// dataset is activated first so that a crash in between is repaired by
+// resuming the load rather than skipping it.
+func (b *appBuilder) completeManifest() error {
+	if b.couponLoad.partial.Load() {
+		b.logger.Warn("coupon load finished with ignored errors, dataset left inactive")
+		return nil
+	}
+	if err := b.addAdminCodes(); err != nil {
+		return err
+	}
+
+	bloomKey, _ := b.config.CouponConfig.DatasetKeys(b.couponLoad.manifest.Version)
+	if err := b.cacheRepository.BFSave(b.couponLoad.ctx, bloomKey); err != nil {
+		return fmt.Errorf("failed to save bloom filter: %w", err)
+	}
+	b.logBloomInfo(b.couponLoad.ctx, b.couponLoad.manifest.Version)
+	if err := b.activateVersion(b.couponLoad.ctx, b.couponLoad.lease, b.couponLoad.manifest.Version); err != nil {
+		return err
+	}
+	b.couponLoad.manifest.Completed = true
+	if err := b.writeManifest(); err != nil {
+		return err
+	}
+	return b.markCouponsReady()
+}
+
+func (b *appBuilder) markCouponsReady() error {
+	load := b.couponLoad
+	if err := b.fencedSet(load.ctx, load.lease, b.config.CouponConfig.Lock.ReadyKey, load.manifest.Fingerprint); err != nil {
+		return fmt.Errorf("failed to store coupons ready marker: %w", err)
+	}
+	return nil
+}
+
+func (b *appBuilder) recordValidLines(fileIdx int, validLines int64) {
+	b.couponLoad.manifest.Files[fileIdx].ValidLines = validLines
+}
+
+func (b *appBuilder) readManifest(ctx context.Context) (*models.CouponManifest, error) {
+	raw, err := b.cacheRepository.Get(ctx, b.config.CouponConfig.ManifestKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest models.CouponManifest
+	if err := json.Unmarshal([]byte(raw), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode coupon manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func (b *appBuilder) writeManifest() error {
+	load := b.couponLoad
+	manifest := load.manifest
+	manifest.FencingToken = load.lease.token
+	manifest.UpdatedAt = time.Now().UTC()
+
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode coupon manifest: %w", err)
+	}
+
+	if err := b.fencedSet(load.ctx, load.lease, b.config.CouponConfig.ManifestKey, raw); err != nil {
+		return fmt.Errorf("failed to store coupon manifest: %w", err)
+	}
+	return nil
+}
+
+func (b *appBuilder) fingerprintFiles() ([]models.CouponManifestFile, error) {
+	files := make([]models.CouponManifestFile, len(b.config.CouponConfig.Files))
+
+	var g errgroup.Group
+	g.SetLimit(utils.Max(1, runtime.NumCPU()/2))
+
+	for i, file := range b.config.CouponConfig.Files {
+		idx, path := i, file.Path
+		g.Go(func() error {
+			size, sum, err := hashFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to hash coupon file %s: %w", path, err)
+			}
+			files[idx] = models.CouponManifestFile{Path: path, Size: size, SHA256: sum}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// datasetFingerprint identifies the coupon dataset: the file contents together
+// with every setting that changes which codes are valid or the order in which
+// they are batched.
+func (b *appBuilder) datasetFingerprint(files []models.CouponManifestFile) (string, error) {
+	cfg := b.config.CouponConfig
+
+	var shards int
+	if cfg.Ingestion.Mode == constants.IngestSharded {
+		shards = b.shardCount()
+	}
+
+	raw, err := json.Marshal(struct {
+		Files      []models.CouponManifestFile
+		Weights    []models.CouponFile
+		Threshold  int
+		BatchSize  int
+		Validation *models.CouponValidator
+		Mode       string
+		Shards     int
+	}{files, cfg.Files, cfg.Threshold, cfg.BatchSize, cfg.Validation, cfg.Ingestion.Mode.String(), shards})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.CopyBuffer(h, f, make([]byte, 1*1024*1024))
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
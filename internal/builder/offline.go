@@ -0,0 +1,170 @@
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"runtime"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"golang.org/x/sync/errgroup"
+)
+
+// Offline runs go through the ingestion code of a coupon load without Redis:
+// the accepted codes are handed to a sink instead of a dataset version, and
+// the files are read directly rather than through CacheDir.
+
+// UseCouponFiles replaces the configured coupon files with paths, each with
+// weight 1, and the threshold with threshold when it is positive. The coupon
+// config is validated again, so the threshold cannot exceed the file count.
+func (b *appBuilder) UseCouponFiles(paths []string, threshold int) error {
+	cfg := *b.config.CouponConfig
+	if len(paths) > 0 {
+		cfg.Files = make([]models.CouponFile, len(paths))
+		for i, path := range paths {
+			cfg.Files[i] = models.CouponFile{Path: path}
+		}
+	}
+	if threshold > 0 {
+		cfg.Threshold = threshold
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid coupon files: %w", err)
+	}
+	b.config.CouponConfig = &cfg
+	return nil
+}
+
+// startOfflineLoad starts tracking a load whose accepted codes go to sink.
+func (b *appBuilder) startOfflineLoad(fingerprint string, sink func(codes []string) error) {
+	if sink == nil {
+		sink = func([]string) error { return nil }
+	}
+
+	cfg := b.config.CouponConfig
+	b.couponLoad = &couponLoad{
+		ctx: b.ctx,
+		manifest: &models.CouponManifest{
+			Fingerprint: fingerprint,
+			Files:       make([]models.CouponManifestFile, len(cfg.Files)),
+		},
+		report: newIngestionReport(cfg, fingerprint),
+		sink:   sink,
+	}
+}
+
+// ValidateCouponFiles checks every line of the coupon files against
+// couponConfig.validation. Codes are not counted across files, so the report
+// only classifies lines. Every file is checked even when one of them fails.
+func (b *appBuilder) ValidateCouponFiles() (*models.CouponIngestionReport, error) {
+	b.startOfflineLoad("", nil)
+
+	var g errgroup.Group
+	g.SetLimit(utils.Max(1, runtime.NumCPU()/2))
+
+	for i, file := range b.config.CouponConfig.Files {
+		idx, path := i, b.sourcePath(file)
+		g.Go(func() error {
+			if _, _, err := b.parseFile(idx, path); err != nil {
+				b.couponLoad.report.fileFailed(idx, err)
+				return err
+			}
+			return nil
+		})
+	}
+
+	err := g.Wait()
+	b.finishIngestionReport(err)
+	return &b.couponLoad.report.report, err
+}
+
+// ComputeCoupons runs the K-of-N count of the coupon files and passes the
+// accepted codes to sink, which may be nil, in the batches a load would write.
+// The fingerprint of the report is the one a load of the same files would
+// record.
+func (b *appBuilder) ComputeCoupons(sink func(codes []string) error) (*models.CouponIngestionReport, error) {
+	files, err := b.fingerprintFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint coupon files: %w", err)
+	}
+	fingerprint, err := b.datasetFingerprint(files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint coupon files: %w", err)
+	}
+
+	b.startOfflineLoad(fingerprint, sink)
+	err = b.processValidData()
+	b.finishIngestionReport(err)
+	return &b.couponLoad.report.report, err
+}
+
+// LookupCoupon tells whether code would be accepted by a load of the coupon
+// files. Revocations are not taken into account.
+func (b *appBuilder) LookupCoupon(code string) (*models.CouponLookup, error) {
+	cfg := b.config.CouponConfig
+	code = utils.NormalizeCode(code, cfg.Validation)
+
+	lookup := &models.CouponLookup{
+		Code:      code,
+		Rejection: utils.CheckCode(code, cfg.Validation),
+		Files:     make([]models.CouponLookupFile, 0, len(cfg.Files)),
+		Threshold: cfg.Threshold,
+	}
+	if lookup.Rejection != "" {
+		return lookup, nil
+	}
+
+	found := make([]bool, len(cfg.Files))
+	var g errgroup.Group
+	g.SetLimit(utils.Max(1, runtime.NumCPU()/2))
+
+	for i, file := range cfg.Files {
+		idx, path := i, file.Path
+		g.Go(func() error {
+			var err error
+			found[idx], err = b.fileHasCode(path, code)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	counter := newCouponCounter(cfg.Files, cfg.Threshold)
+	for i, file := range cfg.Files {
+		lookup.Files = append(lookup.Files, models.CouponLookupFile{
+			Path:     file.Path,
+			Weight:   file.EffectiveWeight(),
+			Required: file.Required,
+			Found:    found[i],
+		})
+		if found[i] {
+			lookup.Weight += file.EffectiveWeight()
+			lookup.Accepted = counter.Add(i, code) || lookup.Accepted
+		}
+	}
+	return lookup, nil
+}
+
+// fileHasCode reports whether a line of filename normalises to code.
+func (b *appBuilder) fileHasCode(filename, code string) (bool, error) {
+	f, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return false, fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 32*1024*1024)
+
+	for scanner.Scan() {
+		if utils.NormalizeCode(scanner.Text(), b.config.CouponConfig.Validation) == code {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("scan error in file %s: %w", filename, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,238 @@
+package builder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"go.uber.org/zap"
+)
+
+const reportTimeFormat = "20060102T150405Z"
+
+// ingestionReport collects the report of the current load. Files are parsed
+// concurrently, so every update holds mu. The quarantine file is only created
+// once a line is rejected.
+type ingestionReport struct {
+	mu             sync.Mutex
+	report         models.CouponIngestionReport
+	quarantine     *os.File
+	quarantineBuf  *bufio.Writer
+	quarantineErr  error
+	quarantinePath string
+}
+
+func newIngestionReport(cfg *models.CouponConfig, fingerprint string) *ingestionReport {
+	r := &ingestionReport{report: models.CouponIngestionReport{
+		Fingerprint:     fingerprint,
+		Mode:            cfg.Ingestion.Mode,
+		StartedAt:       time.Now().UTC(),
+		Files:           make([]models.CouponFileReport, len(cfg.Files)),
+		InvalidByReason: make(map[constants.CodeRejection]int64),
+	}}
+	for i, file := range cfg.Files {
+		r.report.Files[i] = models.CouponFileReport{
+			Path:            file.Path,
+			InvalidByReason: make(map[constants.CodeRejection]int64),
+		}
+	}
+
+	if cfg.Report != nil && cfg.Report.Quarantine {
+		r.quarantinePath = filepath.Join(cfg.Report.Dir, fmt.Sprintf("coupon-quarantine-%s.tsv", r.report.StartedAt.Format(reportTimeFormat)))
+	}
+	return r
+}
+
+// fileTally classifies the lines of one coupon file while it is scanned and
+// hands the result to the report once the file is done.
+type fileTally struct {
+	report  *ingestionReport
+	cfg     *models.CouponValidator
+	fileIdx int
+	stats   models.CouponFileReport
+}
+
+func (b *appBuilder) newFileTally(fileIdx int) *fileTally {
+	return &fileTally{
+		report:  b.couponLoad.report,
+		cfg:     b.config.CouponConfig.Validation,
+		fileIdx: fileIdx,
+		stats:   models.CouponFileReport{InvalidByReason: make(map[constants.CodeRejection]int64)},
+	}
+}
+
+// check normalises a line and reports whether it holds a valid code. Rejected
+// lines other than blank ones are quarantined.
+func (t *fileTally) check(line string) (string, bool) {
+	t.stats.Lines++
+
+	code := utils.NormalizeCode(line, t.cfg)
+	if reason := utils.CheckCode(code, t.cfg); reason != "" {
+		t.stats.Invalid++
+		t.stats.InvalidByReason[reason]++
+		if reason != constants.RejectEmpty {
+			t.report.quarantineLine(t.fileIdx, t.stats.Lines, reason, line)
+		}
+		return "", false
+	}
+
+	t.stats.ValidLines++
+	return code, true
+}
+
+func (t *fileTally) duplicate() {
+	t.stats.Duplicates++
+}
+
+// done adds the tally to the report.
+func (t *fileTally) done() {
+	t.report.mu.Lock()
+	defer t.report.mu.Unlock()
+
+	file := &t.report.report.Files[t.fileIdx]
+	file.Lines += t.stats.Lines
+	file.ValidLines += t.stats.ValidLines
+	file.Invalid += t.stats.Invalid
+	file.Duplicates += t.stats.Duplicates
+	for reason, n := range t.stats.InvalidByReason {
+		file.InvalidByReason[reason] += n
+	}
+}
+
+func (r *ingestionReport) fileFailed(fileIdx int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Files[fileIdx].Error = err.Error()
+}
+
+// addCounts adds what counter saw to the report.
+func (r *ingestionReport) addCounts(counter *couponCounter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.report.DistinctCodes += int64(len(counter.codes))
+	r.report.Accepted += counter.accepted
+	for i, n := range counter.duplicates {
+		r.report.Files[i].Duplicates += n
+	}
+}
+
+// quarantineLine appends a rejected line to the quarantine file as
+// tab-separated file, line number, reason and the line itself. A failing
+// quarantine file is reported once the load is done and never fails it.
+func (r *ingestionReport) quarantineLine(fileIdx int, lineNo int64, reason constants.CodeRejection, line string) {
+	if r.quarantinePath == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.quarantineErr != nil {
+		return
+	}
+	if r.quarantine == nil {
+		if r.quarantineErr = os.MkdirAll(filepath.Dir(r.quarantinePath), 0o755); r.quarantineErr != nil {
+			return
+		}
+		if r.quarantine, r.quarantineErr = os.Create(r.quarantinePath); r.quarantineErr != nil {
+			return
+		}
+		r.quarantineBuf = bufio.NewWriter(r.quarantine)
+	}
+
+	_, r.quarantineErr = fmt.Fprintf(r.quarantineBuf, "%s\t%d\t%s\t%s\n", r.report.Files[fileIdx].Path, lineNo, reason, line)
+}
+
+// closeQuarantine flushes the quarantine file and returns its path, or an
+// empty path when nothing was quarantined.
+func (r *ingestionReport) closeQuarantine() (string, error) {
+	if r.quarantine == nil {
+		return "", r.quarantineErr
+	}
+
+	err := r.quarantineErr
+	if err == nil {
+		err = r.quarantineBuf.Flush()
+	}
+	if closeErr := r.quarantine.Close(); err == nil {
+		err = closeErr
+	}
+	r.quarantine = nil
+	return r.quarantinePath, err
+}
+
+// finishIngestionReport totals the report of the current load, logs it and
+// writes it to the report directory when one is configured.
+func (b *appBuilder) finishIngestionReport(loadErr error) {
+	r := b.couponLoad.report
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &r.report
+	report.Version = b.couponLoad.manifest.Version
+	report.FinishedAt = time.Now().UTC()
+	if loadErr != nil {
+		report.Error = loadErr.Error()
+	}
+
+	for _, file := range report.Files {
+		report.Lines += file.Lines
+		report.ValidLines += file.ValidLines
+		report.Invalid += file.Invalid
+		report.Duplicates += file.Duplicates
+		for reason, n := range file.InvalidByReason {
+			report.InvalidByReason[reason] += n
+		}
+	}
+	report.BelowThreshold = report.DistinctCodes - report.Accepted
+
+	quarantine, err := r.closeQuarantine()
+	if err != nil {
+		b.logger.Warn("failed to write coupon quarantine file", zap.String("path", r.quarantinePath), zap.Error(err))
+	}
+	report.Quarantine = quarantine
+
+	b.logger.Info("coupon ingestion report",
+		zap.Int64("version", report.Version),
+		zap.Int64("lines", report.Lines),
+		zap.Int64("validLines", report.ValidLines),
+		zap.Int64("invalid", report.Invalid),
+		zap.Any("invalidByReason", report.InvalidByReason),
+		zap.Int64("duplicates", report.Duplicates),
+		zap.Int64("distinctCodes", report.DistinctCodes),
+		zap.Int64("accepted", report.Accepted),
+		zap.Int64("belowThreshold", report.BelowThreshold),
+		zap.String("quarantine", report.Quarantine),
+	)
+
+	cfg := b.config.CouponConfig.Report
+	if cfg == nil || cfg.Dir == "" {
+		return
+	}
+
+	path := filepath.Join(cfg.Dir, fmt.Sprintf("coupon-ingestion-%s.json", report.StartedAt.Format(reportTimeFormat)))
+	if err := writeReport(path, report); err != nil {
+		b.logger.Warn("failed to write coupon ingestion report", zap.String("path", path), zap.Error(err))
+		return
+	}
+	b.logger.Info("coupon ingestion report written", zap.String("path", path))
+}
+
+func writeReport(path string, report *models.CouponIngestionReport) error {
+	raw, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
@@ -1,5 +1,7 @@
 package constants
 
+import "slices"
+
 type Environment string
 
 const (
@@ -66,7 +68,9 @@ func (key Compression) String() string {
 type HeaderKey string
 
 const (
-	API_KEY HeaderKey = "api_key"
+	API_KEY             HeaderKey = "api_key"
+	IDEMPOTENCY_KEY     HeaderKey = "Idempotency-Key"
+	IDEMPOTENT_REPLAYED HeaderKey = "Idempotent-Replayed"
 )
 
 func (key HeaderKey) String() string {
@@ -98,3 +102,354 @@ const (
 	ADD    ProcesssStep = "add"
 	UPDATE ProcesssStep = "update"
 )
+
+type CouponVerificationMode string
+
+const (
+	BloomOnly CouponVerificationMode = "bloom-only"
+	ExactOnly CouponVerificationMode = "exact-only"
+	TwoTier   CouponVerificationMode = "two-tier"
+)
+
+func (key CouponVerificationMode) String() string {
+	return string(key)
+}
+
+func (key CouponVerificationMode) IsValid() bool {
+	switch key {
+	case BloomOnly, ExactOnly, TwoTier:
+		return true
+	default:
+		return false
+	}
+}
+
+type DiscountType string
+
+const (
+	PercentageDiscount DiscountType = "percentage"
+	FixedDiscount      DiscountType = "fixed"
+	BuyXGetYDiscount   DiscountType = "buy-x-get-y"
+)
+
+func (key DiscountType) String() string {
+	return string(key)
+}
+
+func (key DiscountType) IsValid() bool {
+	switch key {
+	case PercentageDiscount, FixedDiscount, BuyXGetYDiscount:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaxOrderCouponCodes caps the coupon codes one order may carry.
+const MaxOrderCouponCodes = 10
+
+// StackingOrder is the order the discounts of stacked coupon codes are applied
+// in, each one on what the previous ones left.
+type StackingOrder string
+
+const (
+	// StackPercentageFirst applies item discounts (buy-x-get-y) first, then
+	// percentages, then fixed amounts.
+	StackPercentageFirst StackingOrder = "percentage-first"
+	// StackRequestOrder applies the codes in the order they were sent.
+	StackRequestOrder StackingOrder = "request"
+)
+
+func (key StackingOrder) String() string {
+	return string(key)
+}
+
+func (key StackingOrder) IsValid() bool {
+	switch key {
+	case StackPercentageFirst, StackRequestOrder:
+		return true
+	default:
+		return false
+	}
+}
+
+// StackingResolution decides what happens to coupon codes that cannot all be
+// applied: more than maxCodes, or an exclusive code alongside others.
+type StackingResolution string
+
+const (
+	StackReject       StackingResolution = "reject"
+	StackBestDiscount StackingResolution = "best-discount"
+)
+
+func (key StackingResolution) String() string {
+	return string(key)
+}
+
+func (key StackingResolution) IsValid() bool {
+	switch key {
+	case StackReject, StackBestDiscount:
+		return true
+	default:
+		return false
+	}
+}
+
+type CouponRuleSource string
+
+const (
+	RuleSourceYAML     CouponRuleSource = "yaml"
+	RuleSourceDatabase CouponRuleSource = "database"
+)
+
+func (key CouponRuleSource) String() string {
+	return string(key)
+}
+
+func (key CouponRuleSource) IsValid() bool {
+	switch key {
+	case RuleSourceYAML, RuleSourceDatabase:
+		return true
+	default:
+		return false
+	}
+}
+
+// CustomerSource decides where the customer an order is placed for comes
+// from: the API key alone, or the customerId of the payload when the API key
+// is trusted to act for other customers.
+type CustomerSource string
+
+const (
+	CustomerFromPayload CustomerSource = "payload"
+	CustomerFromAuth    CustomerSource = "auth"
+)
+
+func (key CustomerSource) String() string {
+	return string(key)
+}
+
+func (key CustomerSource) IsValid() bool {
+	switch key {
+	case CustomerFromPayload, CustomerFromAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// OrderStatus is where an order is in its lifecycle. An order is placed,
+// then accepted, prepared, ready and completed; it can be rejected while
+// placed and cancelled until it is ready.
+type OrderStatus string
+
+const (
+	OrderPlaced    OrderStatus = "placed"
+	OrderAccepted  OrderStatus = "accepted"
+	OrderPreparing OrderStatus = "preparing"
+	OrderReady     OrderStatus = "ready"
+	OrderCompleted OrderStatus = "completed"
+	OrderCancelled OrderStatus = "cancelled"
+	OrderRejected  OrderStatus = "rejected"
+)
+
+// orderTransitions lists the statuses each status can move to; the statuses
+// missing from it are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPlaced:    {OrderAccepted, OrderRejected, OrderCancelled},
+	OrderAccepted:  {OrderPreparing, OrderCancelled},
+	OrderPreparing: {OrderReady, OrderCancelled},
+	OrderReady:     {OrderCompleted},
+}
+
+func (key OrderStatus) String() string {
+	return string(key)
+}
+
+func (key OrderStatus) IsValid() bool {
+	switch key {
+	case OrderPlaced, OrderAccepted, OrderPreparing, OrderReady, OrderCompleted, OrderCancelled, OrderRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// CanMoveTo reports whether an order can go from key to next.
+func (key OrderStatus) CanMoveTo(next OrderStatus) bool {
+	return slices.Contains(orderTransitions[key], next)
+}
+
+// Next returns the statuses an order can go to from key.
+func (key OrderStatus) Next() []OrderStatus {
+	return orderTransitions[key]
+}
+
+type ErrorCode string
+
+const (
+	ERR_COUPON_NOT_FOUND        ErrorCode = "COUPON_NOT_FOUND"
+	ERR_COUPON_NOT_APPLICABLE   ErrorCode = "COUPON_NOT_APPLICABLE"
+	ERR_COUPON_NOT_ACTIVE       ErrorCode = "COUPON_NOT_ACTIVE"
+	ERR_COUPON_EXPIRED          ErrorCode = "COUPON_EXPIRED"
+	ERR_COUPON_EXHAUSTED        ErrorCode = "COUPON_EXHAUSTED"
+	ERR_COUPON_ALREADY_REDEEMED ErrorCode = "COUPON_ALREADY_REDEEMED"
+	ERR_COUPONS_NOT_READY       ErrorCode = "COUPONS_NOT_READY"
+	ERR_COUPON_REVOKED          ErrorCode = "COUPON_REVOKED"
+	ERR_COUPON_NOT_ELIGIBLE     ErrorCode = "COUPON_NOT_ELIGIBLE"
+	ERR_COUPON_NOT_STACKABLE    ErrorCode = "COUPON_NOT_STACKABLE"
+	ERR_ORDER_STATUS_TRANSITION ErrorCode = "ORDER_STATUS_TRANSITION"
+)
+
+func (key ErrorCode) String() string {
+	return string(key)
+}
+
+type IngestionMode string
+
+const (
+	IngestMemory  IngestionMode = "memory"
+	IngestSharded IngestionMode = "sharded"
+)
+
+func (key IngestionMode) String() string {
+	return string(key)
+}
+
+func (key IngestionMode) IsValid() bool {
+	switch key {
+	case IngestMemory, IngestSharded:
+		return true
+	default:
+		return false
+	}
+}
+
+type IngestionState string
+
+const (
+	IngestionPending IngestionState = "pending"
+	IngestionLoading IngestionState = "loading"
+	IngestionReady   IngestionState = "ready"
+	IngestionFailed  IngestionState = "failed"
+)
+
+func (key IngestionState) String() string {
+	return string(key)
+}
+
+type NotReadyPolicy string
+
+const (
+	NotReadyReject       NotReadyPolicy = "reject"
+	NotReadyIgnoreCoupon NotReadyPolicy = "ignore-coupon"
+	NotReadyBestEffort   NotReadyPolicy = "best-effort"
+)
+
+func (key NotReadyPolicy) String() string {
+	return string(key)
+}
+
+func (key NotReadyPolicy) IsValid() bool {
+	switch key {
+	case NotReadyReject, NotReadyIgnoreCoupon, NotReadyBestEffort:
+		return true
+	default:
+		return false
+	}
+}
+
+type BloomBackend string
+
+const (
+	BloomRedis  BloomBackend = "redis"
+	BloomNative BloomBackend = "native"
+)
+
+func (key BloomBackend) String() string {
+	return string(key)
+}
+
+func (key BloomBackend) IsValid() bool {
+	switch key {
+	case BloomRedis, BloomNative:
+		return true
+	default:
+		return false
+	}
+}
+
+type BloomStore string
+
+const (
+	BloomStoreRedis BloomStore = "redis"
+	BloomStoreFile  BloomStore = "file"
+)
+
+func (key BloomStore) String() string {
+	return string(key)
+}
+
+func (key BloomStore) IsValid() bool {
+	switch key {
+	case BloomStoreRedis, BloomStoreFile:
+		return true
+	default:
+		return false
+	}
+}
+
+type CodeCase string
+
+const (
+	CasePreserve CodeCase = "preserve"
+	CaseUpper    CodeCase = "upper"
+	CaseLower    CodeCase = "lower"
+)
+
+func (key CodeCase) String() string {
+	return string(key)
+}
+
+func (key CodeCase) IsValid() bool {
+	switch key {
+	case CasePreserve, CaseUpper, CaseLower:
+		return true
+	default:
+		return false
+	}
+}
+
+type CodeChecksum string
+
+const (
+	ChecksumLuhn  CodeChecksum = "luhn"
+	ChecksumMod36 CodeChecksum = "mod36"
+)
+
+func (key CodeChecksum) String() string {
+	return string(key)
+}
+
+func (key CodeChecksum) IsValid() bool {
+	switch key {
+	case ChecksumLuhn, ChecksumMod36:
+		return true
+	default:
+		return false
+	}
+}
+
+type CodeRejection string
+
+const (
+	RejectEmpty      CodeRejection = "empty"
+	RejectLength     CodeRejection = "length"
+	RejectCharacters CodeRejection = "characters"
+	RejectPattern    CodeRejection = "pattern"
+	RejectChecksum   CodeRejection = "checksum"
+)
+
+func (key CodeRejection) String() string {
+	return string(key)
+}
@@ -5,6 +5,8 @@ type CtxKey string
 const (
 	CtxRequestID CtxKey = "RequestID"
 	CtxTraceID   CtxKey = "TraceID"
+	CtxClientID  CtxKey = "ClientID"
+	CtxTrusted   CtxKey = "Trusted"
 )
 
 func (key CtxKey) String() string {
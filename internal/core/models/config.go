@@ -3,30 +3,49 @@ package models
 import (
 	"errors"
 	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	App          *App          `yaml:"app"`
 	Logger       *Logger       `yaml:"logger"`
 	ApiKey       *ApiKey       `yaml:"apiKey"`
+	AdminApiKey  *ApiKey       `yaml:"adminApiKey"`
 	CouponConfig *CouponConfig `yaml:"couponConfig"`
 	Cache        *Cache        `yaml:"cache"`
 	Database     *Database     `yaml:"database"`
+	Idempotency  *Idempotency  `yaml:"idempotency"`
 }
 
 func (c Config) Validate() error {
-	return validation.ValidateStruct(&c,
+	err := validation.ValidateStruct(&c,
 		validation.Field(&c.App, validation.Required, validation.NotNil),
 		validation.Field(&c.Logger, validation.Required, validation.NotNil),
 		validation.Field(&c.CouponConfig, validation.Required, validation.NotNil),
 		validation.Field(&c.ApiKey, validation.Required, validation.NotNil),
+		validation.Field(&c.AdminApiKey, validation.Required, validation.NotNil),
 		validation.Field(&c.Cache, validation.Required, validation.NotNil),
+		validation.Field(&c.Idempotency),
 	)
+	if err != nil {
+		return err
+	}
+
+	// Customers are identified by their API key, so rules bound to customers
+	// would never match and redemption histories never be readable without one.
+	if !c.ApiKey.Enabled && c.CouponConfig.Rules != nil && c.CouponConfig.Rules.Restricted() {
+		return fmt.Errorf("couponConfig.rules restrict coupons to customers or segments, which requires apiKey.enabled")
+	}
+	return nil
 }
 
 type App struct {
@@ -51,6 +70,19 @@ type Server struct {
 	Compression          bool          `yaml:"compression"`
 	GracefulShutdownTime time.Duration `yaml:"gracefulShutdownTime"`
 	Port                 int           `yaml:"port"`
+	MaxRequestBodySize   int           `yaml:"maxRequestBodySize"`
+}
+
+// defaultMaxRequestBodySize matches fasthttp's own default.
+const defaultMaxRequestBodySize = 4 * 1024 * 1024
+
+// BodyLimit returns the largest request body accepted outside of coupon
+// uploads.
+func (s *Server) BodyLimit() int {
+	if s.MaxRequestBodySize > 0 {
+		return s.MaxRequestBodySize
+	}
+	return defaultMaxRequestBodySize
 }
 
 func (s Server) Validate() error {
@@ -63,6 +95,24 @@ func (s Server) Validate() error {
 			return nil
 		})),
 		validation.Field(&s.GracefulShutdownTime, validation.Required, validation.Min(5*time.Second), validation.Max(5*time.Minute)),
+		validation.Field(&s.MaxRequestBodySize, validation.Min(0)),
+	)
+}
+
+// Idempotency configures the Idempotency-Key header of order creation. The
+// response to a key is kept under KeyPrefix for TTL; LockTTL bounds how long
+// a request being handled holds its key.
+type Idempotency struct {
+	KeyPrefix string        `yaml:"keyPrefix"`
+	TTL       time.Duration `yaml:"ttl"`
+	LockTTL   time.Duration `yaml:"lockTTL"`
+}
+
+func (i Idempotency) Validate() error {
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.KeyPrefix, validation.Required),
+		validation.Field(&i.TTL, validation.Required, validation.Min(time.Minute)),
+		validation.Field(&i.LockTTL, validation.Required, validation.Min(time.Second), validation.Max(i.TTL)),
 	)
 }
 
@@ -82,53 +132,432 @@ func (l Logger) Validate() error {
 	)
 }
 
+// ApiKey lists the API keys allowed on a set of endpoints. With
+// CustomerSource payload, the TrustedApiKeys among them may place orders and
+// read redemptions for any customer; every other key only acts for itself.
+// The TrustedApiKeys also act as staff on order statuses.
 type ApiKey struct {
-	Enabled        bool            `yaml:"enabled"`
-	AllowedApiKeys map[string]bool `yaml:"allowedApiKeys"`
+	Enabled        bool                     `yaml:"enabled"`
+	AllowedApiKeys map[string]bool          `yaml:"allowedApiKeys"`
+	TrustedApiKeys map[string]bool          `yaml:"trustedApiKeys"`
+	CustomerSource constants.CustomerSource `yaml:"customerSource"`
+}
+
+// Customers returns the customer source, auth when none is configured.
+func (l ApiKey) Customers() constants.CustomerSource {
+	if l.CustomerSource == "" {
+		return constants.CustomerFromAuth
+	}
+	return l.CustomerSource
 }
 
 func (l ApiKey) Validate() error {
 	return validation.ValidateStruct(&l,
 		validation.Field(&l.AllowedApiKeys, validation.When(l.Enabled, validation.Required, validation.NotNil)),
+		validation.Field(&l.TrustedApiKeys, validation.By(func(value interface{}) error {
+			for key := range l.TrustedApiKeys {
+				if !l.AllowedApiKeys[key] {
+					return fmt.Errorf("trusted API key is not an allowed API key")
+				}
+			}
+			return nil
+		})),
+		validation.Field(&l.CustomerSource, validation.By(func(value interface{}) error {
+			source, _ := value.(constants.CustomerSource)
+			if source != "" && !source.IsValid() {
+				return fmt.Errorf("invalid customerSource: %s", source)
+			}
+			return nil
+		})),
 	)
 }
 
 type CouponConfig struct {
-	IgnoreUnzipErrors bool             `yaml:"ignoreUnzipErrors"`
-	BloomKey          string           `yaml:"bloomKey"`
-	ExactSet          string           `yaml:"exactSet"`
-	BatchSize         int              `yaml:"batchSize"`
-	Files             []string         `yaml:"files"`
-	Validation        *CouponValidator `yaml:"validation"`
+	IgnoreUnzipErrors bool                             `yaml:"ignoreUnzipErrors"`
+	CacheDir          string                           `yaml:"cacheDir"`
+	BloomKey          string                           `yaml:"bloomKey"`
+	ExactSet          string                           `yaml:"exactSet"`
+	RedemptionKey     string                           `yaml:"redemptionKey"`
+	ManifestKey       string                           `yaml:"manifestKey"`
+	AdminCodesKey     string                           `yaml:"adminCodesKey"`
+	RevokedKey        string                           `yaml:"revokedKey"`
+	VerificationMode  constants.CouponVerificationMode `yaml:"verificationMode"`
+	NotReadyPolicy    constants.NotReadyPolicy         `yaml:"notReadyPolicy"`
+	BatchSize         int                              `yaml:"batchSize"`
+	MaxUploadSize     int64                            `yaml:"maxUploadSize"`
+	Threshold         int                              `yaml:"threshold"`
+	Files             []CouponFile                     `yaml:"files"`
+	Validation        *CouponValidator                 `yaml:"validation"`
+	Rules             *CouponRules                     `yaml:"rules"`
+	Ingestion         *CouponIngestion                 `yaml:"ingestion"`
+	Lock              *CouponLock                      `yaml:"lock"`
+	Versions          *CouponVersions                  `yaml:"versions"`
+	Bloom             *CouponBloom                     `yaml:"bloom"`
+	Compaction        *CouponCompaction                `yaml:"compaction"`
+	Report            *CouponReport                    `yaml:"report"`
+	Stacking          *CouponStacking                  `yaml:"stacking"`
 }
 
 func (c CouponConfig) Validate() error {
-	return validation.ValidateStruct(&c,
-		validation.Field(&c.Files, validation.Required, validation.Length(1, 3)),
+	err := validation.ValidateStruct(&c,
+		validation.Field(&c.Files, validation.Required, validation.Length(1, 0)),
+		validation.Field(&c.Threshold, validation.Required, validation.Min(1)),
 		validation.Field(&c.Validation, validation.Required, validation.NotNil),
+		validation.Field(&c.Rules, validation.Required, validation.NotNil),
+		validation.Field(&c.Ingestion, validation.Required, validation.NotNil),
+		validation.Field(&c.Lock, validation.Required, validation.NotNil),
+		validation.Field(&c.Versions, validation.Required, validation.NotNil),
+		validation.Field(&c.Bloom, validation.Required, validation.NotNil),
+		validation.Field(&c.Compaction),
+		validation.Field(&c.Report),
+		validation.Field(&c.Stacking),
 		validation.Field(&c.BloomKey, validation.Required),
 		validation.Field(&c.ExactSet, validation.Required),
+		validation.Field(&c.RedemptionKey, validation.Required),
+		validation.Field(&c.ManifestKey, validation.Required),
+		validation.Field(&c.AdminCodesKey, validation.Required),
+		validation.Field(&c.RevokedKey, validation.Required),
+		validation.Field(&c.VerificationMode, validation.Required, validation.By(func(value interface{}) error {
+			mode, _ := value.(constants.CouponVerificationMode)
+			if !mode.IsValid() {
+				return fmt.Errorf("invalid verificationMode: %s", mode)
+			}
+			return nil
+		})),
+		validation.Field(&c.NotReadyPolicy, validation.Required, validation.By(func(value interface{}) error {
+			policy, _ := value.(constants.NotReadyPolicy)
+			if !policy.IsValid() {
+				return fmt.Errorf("invalid notReadyPolicy: %s", policy)
+			}
+			return nil
+		})),
 		validation.Field(&c.BatchSize, validation.Required, validation.Min(100), validation.Max(10000)),
+		validation.Field(&c.MaxUploadSize, validation.Required, validation.Min(int64(1))),
+	)
+	if err != nil {
+		return err
+	}
+
+	var totalWeight int
+	paths := make(map[string]bool, len(c.Files))
+	for _, file := range c.Files {
+		if paths[file.Path] {
+			return fmt.Errorf("duplicate coupon file: %s", file.Path)
+		}
+		paths[file.Path] = true
+		totalWeight += file.EffectiveWeight()
+	}
+
+	if c.Threshold > totalWeight {
+		return fmt.Errorf("threshold (%d) cannot be greater than the total file weight (%d)", c.Threshold, totalWeight)
+	}
+
+	return nil
+}
+
+// StackingRules returns the stacking config, which defaults to a single coupon
+// code per order.
+func (c CouponConfig) StackingRules() CouponStacking {
+	if c.Stacking == nil {
+		return CouponStacking{MaxCodes: 1, Order: constants.StackPercentageFirst, Resolution: constants.StackReject}
+	}
+	return *c.Stacking
+}
+
+// DatasetKeys returns the Bloom filter and exact set keys of a dataset version.
+func (c CouponConfig) DatasetKeys(version int64) (string, string) {
+	return fmt.Sprintf("%s:v%d", c.BloomKey, version), fmt.Sprintf("%s:v%d", c.ExactSet, version)
+}
+
+// CouponFile is a coupon source. In YAML it is either a plain path or a
+// mapping with a weight and a required flag.
+type CouponFile struct {
+	Path     string `yaml:"path"`
+	Weight   int    `yaml:"weight"`
+	Required bool   `yaml:"required"`
+}
+
+func (c *CouponFile) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Path)
+	}
+
+	type plain CouponFile
+	return value.Decode((*plain)(c))
+}
+
+// EffectiveWeight is the weight the file contributes towards Threshold.
+func (c CouponFile) EffectiveWeight() int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+func (c CouponFile) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Path, validation.Required),
+		validation.Field(&c.Weight, validation.Min(0)),
+	)
+}
+
+// CouponRules holds the discount rules and the customer segments rules can be
+// restricted to, as segment name to customer IDs.
+type CouponRules struct {
+	Source      constants.CouponRuleSource          `yaml:"source"`
+	Definitions []ingressModels.CouponRule          `yaml:"definitions"`
+	Segments    map[string]ingressModels.StringList `yaml:"segments"`
+}
+
+func (c CouponRules) Validate() error {
+	err := validation.ValidateStruct(&c,
+		validation.Field(&c.Source, validation.Required, validation.By(func(value interface{}) error {
+			v, _ := value.(constants.CouponRuleSource)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid rules source: %s", v)
+			}
+			return nil
+		})),
+		validation.Field(&c.Definitions, validation.When(c.Source == constants.RuleSourceYAML, validation.Required)),
+	)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Definitions))
+	for _, rule := range c.Definitions {
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate coupon rule name: %s", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return nil
+}
+
+// Restricted reports whether any YAML rule is restricted to customers or
+// segments, or any segment is defined.
+func (c CouponRules) Restricted() bool {
+	if len(c.Segments) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(c.Definitions, func(rule ingressModels.CouponRule) bool {
+		return rule.Restricted()
+	})
+}
+
+type CouponIngestion struct {
+	Mode             constants.IngestionMode `yaml:"mode"`
+	MemoryLimitMB    int                     `yaml:"memoryLimitMB"`
+	Shards           int                     `yaml:"shards"`
+	TempDir          string                  `yaml:"tempDir"`
+	ProgressInterval int                     `yaml:"progressInterval"`
+	// RetryBackoff is the wait before retrying a failed load at boot, doubled
+	// after every failure up to MaxRetryBackoff.
+	RetryBackoff    time.Duration `yaml:"retryBackoff"`
+	MaxRetryBackoff time.Duration `yaml:"maxRetryBackoff"`
+}
+
+func (c CouponIngestion) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Mode, validation.Required, validation.By(func(value interface{}) error {
+			v, _ := value.(constants.IngestionMode)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid ingestion mode: %s", v)
+			}
+			return nil
+		})),
+		validation.Field(&c.MemoryLimitMB, validation.When(c.Mode == constants.IngestSharded && c.Shards == 0, validation.Required, validation.Min(16))),
+		validation.Field(&c.Shards, validation.Min(0), validation.Max(4096)),
+		validation.Field(&c.ProgressInterval, validation.Min(0)),
+		validation.Field(&c.RetryBackoff, validation.Min(time.Duration(0))),
+		validation.Field(&c.MaxRetryBackoff, validation.Min(c.RetryBackoff)),
+	)
+}
+
+// CouponBloom selects the Bloom filter implementation and its sizing. The
+// redis backend needs the RedisBloom module; the native backend keeps the
+// filter in process and persists it to Store, so it works with any Redis.
+// Capacity is the minimum reserved; the load reserves room for the number of
+// codes it counted when that is larger. Expansion only applies to RedisBloom.
+type CouponBloom struct {
+	Backend   constants.BloomBackend `yaml:"backend"`
+	Capacity  uint64                 `yaml:"capacity"`
+	ErrorRate float64                `yaml:"errorRate"`
+	Expansion int                    `yaml:"expansion"`
+	Store     constants.BloomStore   `yaml:"store"`
+	Dir       string                 `yaml:"dir"`
+	// RefreshInterval is how often a replica checks that its native filters
+	// are still the saved ones, 0 meaning on every lookup.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+func (c CouponBloom) Validate() error {
+	native := c.Backend == constants.BloomNative
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Backend, validation.Required, validation.By(func(value interface{}) error {
+			v, _ := value.(constants.BloomBackend)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid bloom backend: %s", v)
+			}
+			return nil
+		})),
+		validation.Field(&c.Capacity, validation.Required, validation.Min(uint64(1000))),
+		validation.Field(&c.ErrorRate, validation.Required, validation.Min(0.000001), validation.Max(0.5)),
+		validation.Field(&c.Expansion, validation.Min(0), validation.Max(32)),
+		validation.Field(&c.Store, validation.When(native, validation.Required, validation.By(func(value interface{}) error {
+			v, _ := value.(constants.BloomStore)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid bloom store: %s", v)
+			}
+			return nil
+		}))),
+		validation.Field(&c.Dir, validation.When(native && c.Store == constants.BloomStoreFile, validation.Required)),
+		validation.Field(&c.RefreshInterval, validation.Min(time.Duration(0)), validation.Max(time.Minute)),
 	)
 }
 
+// CouponLock configures the Redis lease that lets a single replica load the
+// coupons while the others wait for the ready marker.
+type CouponLock struct {
+	Key           string        `yaml:"key"`
+	ReadyKey      string        `yaml:"readyKey"`
+	TTL           time.Duration `yaml:"ttl"`
+	RenewInterval time.Duration `yaml:"renewInterval"`
+	PollInterval  time.Duration `yaml:"pollInterval"`
+	WaitTimeout   time.Duration `yaml:"waitTimeout"`
+}
+
+// FenceKey returns the key of the fencing counter, bumped every time the lock
+// is acquired.
+func (c CouponLock) FenceKey() string {
+	return c.Key + ":fence"
+}
+
+func (c CouponLock) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Key, validation.Required),
+		validation.Field(&c.ReadyKey, validation.Required),
+		validation.Field(&c.TTL, validation.Required, validation.Min(time.Second)),
+		validation.Field(&c.RenewInterval, validation.Required, validation.Min(100*time.Millisecond), validation.Max(c.TTL/2)),
+		validation.Field(&c.PollInterval, validation.Required, validation.Min(100*time.Millisecond)),
+		validation.Field(&c.WaitTimeout, validation.Required, validation.Min(time.Second)),
+	)
+}
+
+// CouponVersions configures the versioned coupon datasets. ActiveKey points at
+// the version served to orders; Retention previous versions are kept for
+// rollback.
+type CouponVersions struct {
+	ActiveKey string `yaml:"activeKey"`
+	Retention int    `yaml:"retention"`
+}
+
+func (c CouponVersions) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.ActiveKey, validation.Required),
+		validation.Field(&c.Retention, validation.Min(0), validation.Max(100)),
+	)
+}
+
+// CouponCompaction configures the periodic rebuild of the active dataset
+// without its revoked codes. Bloom filters cannot delete entries, so revoked
+// codes otherwise stay in the filter until the coupon files change.
+type CouponCompaction struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (c CouponCompaction) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Interval, validation.When(c.Enabled, validation.Required, validation.Min(time.Minute))),
+	)
+}
+
+// CouponReport configures the ingestion report. Every load logs its report;
+// with Dir set it is also written there as JSON, and with Quarantine the
+// rejected lines are copied to a quarantine file next to it.
+type CouponReport struct {
+	Dir        string `yaml:"dir"`
+	Quarantine bool   `yaml:"quarantine"`
+}
+
+func (c CouponReport) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Dir, validation.When(c.Quarantine, validation.Required)),
+	)
+}
+
+// CouponStacking decides how the coupon codes of one order combine: at most
+// MaxCodes are applied, in Order, and Resolution settles codes that cannot all
+// be applied.
+type CouponStacking struct {
+	MaxCodes   int                          `yaml:"maxCodes"`
+	Order      constants.StackingOrder      `yaml:"order"`
+	Resolution constants.StackingResolution `yaml:"resolution"`
+}
+
+func (c CouponStacking) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.MaxCodes, validation.Required, validation.Min(1), validation.Max(constants.MaxOrderCouponCodes)),
+		validation.Field(&c.Order, validation.Required, validation.By(func(value interface{}) error {
+			order, _ := value.(constants.StackingOrder)
+			if !order.IsValid() {
+				return fmt.Errorf("invalid stacking order: %s", order)
+			}
+			return nil
+		})),
+		validation.Field(&c.Resolution, validation.Required, validation.By(func(value interface{}) error {
+			resolution, _ := value.(constants.StackingResolution)
+			if !resolution.IsValid() {
+				return fmt.Errorf("invalid stacking resolution: %s", resolution)
+			}
+			return nil
+		})),
+	)
+}
+
+// CouponValidator describes a well-formed coupon code. Codes are first
+// normalised: Separators are stripped and the case is folded per Case. The
+// normalised code must then fit the length bounds, AllowedCharacters, Pattern
+// (a regular expression) or Format (see FormatExpression), and Checksum.
 type CouponValidator struct {
 	MinLength         int                         `yaml:"minLength"`
 	MaxLength         int                         `yaml:"maxLength"`
 	AllowedCharacters constants.AllowedCharacters `yaml:"allowedCharacters"`
+	Pattern           string                      `yaml:"pattern"`
+	Format            string                      `yaml:"format"`
+	Case              constants.CodeCase          `yaml:"case"`
+	Separators        string                      `yaml:"separators"`
+	Checksum          constants.CodeChecksum      `yaml:"checksum"`
 }
 
 func (c CouponValidator) Validate() error {
 	err := validation.ValidateStruct(&c,
 		validation.Field(&c.MinLength, validation.Required, validation.Min(1)),
 		validation.Field(&c.MaxLength, validation.Required, validation.Min(1)),
-		validation.Field(&c.AllowedCharacters, validation.Required, validation.By(func(value interface{}) error {
+		validation.Field(&c.AllowedCharacters, validation.When(c.Pattern == "" && c.Format == "", validation.Required), validation.When(c.AllowedCharacters != "", validation.By(func(value interface{}) error {
 			v, _ := value.(constants.AllowedCharacters)
 			if !v.IsValid() {
 				return fmt.Errorf("invalid allowedCharacters value: %s", v)
 			}
 			return nil
-		})),
+		}))),
+		validation.Field(&c.Format, validation.When(c.Pattern != "", validation.Empty.Error("cannot be combined with pattern"))),
+		validation.Field(&c.Case, validation.When(c.Case != "", validation.By(func(value interface{}) error {
+			v, _ := value.(constants.CodeCase)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid case value: %s", v)
+			}
+			return nil
+		}))),
+		validation.Field(&c.Checksum, validation.When(c.Checksum != "", validation.By(func(value interface{}) error {
+			v, _ := value.(constants.CodeChecksum)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid checksum value: %s", v)
+			}
+			return nil
+		}))),
 	)
 	if err != nil {
 		return err
@@ -138,9 +567,86 @@ func (c CouponValidator) Validate() error {
 		return fmt.Errorf("minLength (%d) cannot be greater than maxLength (%d)", c.MinLength, c.MaxLength)
 	}
 
+	expr, err := c.Expression()
+	if err != nil {
+		return err
+	}
+	if expr != "" {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid coupon code pattern: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// Expression returns the anchored regular expression a normalised code must
+// match: Pattern, or Format translated by FormatExpression. It is empty when
+// neither is set.
+func (c CouponValidator) Expression() (string, error) {
+	expr := c.Pattern
+	if c.Format != "" {
+		var err error
+		if expr, err = FormatExpression(c.Format); err != nil {
+			return "", err
+		}
+	}
+	if expr == "" {
+		return "", nil
+	}
+	return "^(?:" + expr + ")$", nil
+}
+
+// FormatExpression translates the coupon format DSL into a regular
+// expression. In a format '#' is a digit, '@' a letter, '*' a letter or digit,
+// '{n}' or '{n,m}' repeats the preceding token, '\' makes the next character
+// literal and any other character stands for itself. "PRT-#{6}*" matches
+// PRT-123456X.
+func FormatExpression(format string) (string, error) {
+	var sb strings.Builder
+	runes := []rune(format)
+	repeatable := false
+
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '#':
+			sb.WriteString(`[0-9]`)
+			repeatable = true
+		case '@':
+			sb.WriteString(`[A-Za-z]`)
+			repeatable = true
+		case '*':
+			sb.WriteString(`[A-Za-z0-9]`)
+			repeatable = true
+		case '\\':
+			if i+1 == len(runes) {
+				return "", fmt.Errorf("invalid coupon format %q: trailing escape", format)
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+			repeatable = true
+		case '{':
+			end := slices.Index(runes[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("invalid coupon format %q: unclosed repetition", format)
+			}
+			count := string(runes[i+1 : i+end])
+			if !repeatable || !formatRepeat.MatchString(count) {
+				return "", fmt.Errorf("invalid coupon format %q: bad repetition {%s}", format, count)
+			}
+			sb.WriteString("{" + count + "}")
+			i += end
+			repeatable = false
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+			repeatable = true
+		}
+	}
+	return sb.String(), nil
+}
+
+var formatRepeat = regexp.MustCompile(`^[0-9]+(,[0-9]*)?$`)
+
 type Cache struct {
 	Name           int           `yaml:"name"`
 	Host           string        `yaml:"host"`
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+)
+
+type CouponVerificationStats struct {
+	Mode              constants.CouponVerificationMode `json:"mode"`
+	Checks            uint64                           `json:"checks"`
+	BloomHits         uint64                           `json:"bloomHits"`
+	ExactHits         uint64                           `json:"exactHits"`
+	FalsePositives    uint64                           `json:"falsePositives"`
+	FalsePositiveRate float64                          `json:"falsePositiveRate"`
+}
+
+// CouponManifest records the state of the coupon load in Redis so unchanged
+// files are not reloaded and an interrupted load resumes where it stopped.
+type CouponManifest struct {
+	Fingerprint      string               `json:"fingerprint"`
+	Files            []CouponManifestFile `json:"files"`
+	CodesLoaded      int64                `json:"codesLoaded"`
+	CompletedBatches int64                `json:"completedBatches"`
+	Completed        bool                 `json:"completed"`
+	Version          int64                `json:"version"`
+	FencingToken     int64                `json:"fencingToken"`
+	UpdatedAt        time.Time            `json:"updatedAt"`
+}
+
+// CouponIngestionReport summarises one coupon load: how the lines of every
+// file were classified and how many distinct codes met the threshold.
+type CouponIngestionReport struct {
+	Fingerprint     string                            `json:"fingerprint"`
+	Version         int64                             `json:"version"`
+	Mode            constants.IngestionMode           `json:"mode"`
+	StartedAt       time.Time                         `json:"startedAt"`
+	FinishedAt      time.Time                         `json:"finishedAt"`
+	Files           []CouponFileReport                `json:"files"`
+	Lines           int64                             `json:"lines"`
+	ValidLines      int64                             `json:"validLines"`
+	Invalid         int64                             `json:"invalid"`
+	InvalidByReason map[constants.CodeRejection]int64 `json:"invalidByReason"`
+	Duplicates      int64                             `json:"duplicates"`
+	DistinctCodes   int64                             `json:"distinctCodes"`
+	Accepted        int64                             `json:"accepted"`
+	BelowThreshold  int64                             `json:"belowThreshold"`
+	Quarantine      string                            `json:"quarantine,omitempty"`
+	Error           string                            `json:"error,omitempty"`
+}
+
+// CouponFileReport classifies the lines of one coupon file. Duplicates are
+// valid lines repeating a code already seen in the same file.
+type CouponFileReport struct {
+	Path            string                            `json:"path"`
+	Lines           int64                             `json:"lines"`
+	ValidLines      int64                             `json:"validLines"`
+	Invalid         int64                             `json:"invalid"`
+	InvalidByReason map[constants.CodeRejection]int64 `json:"invalidByReason"`
+	Duplicates      int64                             `json:"duplicates"`
+	Error           string                            `json:"error,omitempty"`
+}
+
+// CouponLookup tells whether a code would be accepted by a load of the coupon
+// files, without loading them. Files is empty when the code is rejected by
+// the validation rules.
+type CouponLookup struct {
+	Code      string                  `json:"code"`
+	Rejection constants.CodeRejection `json:"rejection,omitempty"`
+	Files     []CouponLookupFile      `json:"files"`
+	Weight    int                     `json:"weight"`
+	Threshold int                     `json:"threshold"`
+	Accepted  bool                    `json:"accepted"`
+}
+
+// CouponLookupFile tells whether one coupon file holds the looked up code.
+type CouponLookupFile struct {
+	Path     string `json:"path"`
+	Weight   int    `json:"weight"`
+	Required bool   `json:"required"`
+	Found    bool   `json:"found"`
+}
+
+// CouponLeaseFence identifies a holder of the coupon ingestion lease for a
+// fenced write: the lock at LockKey must still hold Value, and the fencing
+// counter at FenceKey, bumped on every acquisition, must still be Token.
+type CouponLeaseFence struct {
+	LockKey  string
+	Value    string
+	FenceKey string
+	Token    int64
+}
+
+// CouponDataset is stored under the active key: the dataset version served to
+// orders and the retained previous versions, most recent first.
+type CouponDataset struct {
+	Version     int64     `json:"version"`
+	Previous    []int64   `json:"previous"`
+	ActivatedAt time.Time `json:"activatedAt"`
+}
+
+// CouponRevocation records when, why and by whom a coupon code was revoked.
+type CouponRevocation struct {
+	Code      string    `json:"code"`
+	Reason    string    `json:"reason,omitempty"`
+	RevokedBy string    `json:"revokedBy,omitempty"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+// CouponCompactionResult describes a compaction of the active dataset: the
+// version it replaced, the version it activated, and the codes it kept and
+// dropped. From equals To when there was nothing to compact.
+type CouponCompactionResult struct {
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+	Codes   int64 `json:"codes"`
+	Dropped int64 `json:"dropped"`
+}
+
+// CouponStatus is the admin view of a single coupon code.
+type CouponStatus struct {
+	Code        string            `json:"code"`
+	FormatValid bool              `json:"formatValid"`
+	Version     int64             `json:"version"`
+	BloomHit    bool              `json:"bloomHit"`
+	ExactMember bool              `json:"exactMember"`
+	AdminAdded  bool              `json:"adminAdded"`
+	Revocation  *CouponRevocation `json:"revocation,omitempty"`
+	Redemptions int64             `json:"redemptions"`
+}
+
+// BloomInfo describes a Bloom filter, as reported by BF.INFO.
+type BloomInfo struct {
+	Capacity      int64 `json:"capacity"`
+	Size          int64 `json:"size"`
+	Filters       int64 `json:"filters"`
+	ItemsInserted int64 `json:"itemsInserted"`
+	ExpansionRate int64 `json:"expansionRate"`
+}
+
+type CouponManifestFile struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	ValidLines int64  `json:"validLines"`
+}
+
+type CouponIngestionStatus struct {
+	State            constants.IngestionState `json:"state"`
+	StartedAt        *time.Time               `json:"startedAt,omitempty"`
+	CompletedAt      *time.Time               `json:"completedAt,omitempty"`
+	Files            int                      `json:"files"`
+	CompletedBatches int64                    `json:"completedBatches"`
+	CodesLoaded      int64                    `json:"codesLoaded"`
+	Error            string                   `json:"error,omitempty"`
+	Attempts         int                      `json:"attempts"`
+	RetryAt          *time.Time               `json:"retryAt,omitempty"`
+}
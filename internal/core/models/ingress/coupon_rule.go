@@ -0,0 +1,132 @@
+package ingress
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// CouponRule describes the discount granted by a coupon. A rule without Codes
+// applies to every valid coupon that has no rule of its own.
+type CouponRule struct {
+	ID             int64                  `json:"id" yaml:"-" gorm:"primaryKey;autoIncrement"`
+	Name           string                 `json:"name" yaml:"name" gorm:"uniqueIndex;not null"`
+	Type           constants.DiscountType `json:"type" yaml:"type" gorm:"not null"`
+	Value          float64                `json:"value,omitempty" yaml:"value"`
+	Codes          StringList             `json:"codes,omitempty" yaml:"codes" gorm:"type:jsonb"`
+	MinBasketTotal float64                `json:"minBasketTotal,omitempty" yaml:"minBasketTotal"`
+	Categories     StringList             `json:"categories,omitempty" yaml:"categories" gorm:"type:jsonb"`
+	ProductIDs     Int64List              `json:"productIds,omitempty" yaml:"productIds" gorm:"type:jsonb"`
+	BuyQuantity    int                    `json:"buyQuantity,omitempty" yaml:"buyQuantity"`
+	GetQuantity    int                    `json:"getQuantity,omitempty" yaml:"getQuantity"`
+	MaxDiscount    float64                `json:"maxDiscount,omitempty" yaml:"maxDiscount"`
+	Priority       int                    `json:"priority,omitempty" yaml:"priority"`
+	Customers      StringList             `json:"customers,omitempty" yaml:"customers" gorm:"type:jsonb"`
+	Segments       StringList             `json:"segments,omitempty" yaml:"segments" gorm:"type:jsonb"`
+	Exclusive      bool                   `json:"exclusive,omitempty" yaml:"exclusive"`
+
+	ValidFrom                 *time.Time `json:"validFrom,omitempty" yaml:"validFrom"`
+	ValidUntil                *time.Time `json:"validUntil,omitempty" yaml:"validUntil"`
+	MaxRedemptions            int64      `json:"maxRedemptions,omitempty" yaml:"maxRedemptions"`
+	MaxRedemptionsPerCustomer int64      `json:"maxRedemptionsPerCustomer,omitempty" yaml:"maxRedemptionsPerCustomer"`
+	SingleUse                 bool       `json:"singleUse,omitempty" yaml:"singleUse"`
+}
+
+// RedemptionLimits returns the global and per-customer redemption caps for a
+// code under this rule, zero meaning unlimited.
+func (r *CouponRule) RedemptionLimits() (total int64, perCustomer int64) {
+	if r.SingleUse {
+		return 1, 1
+	}
+	return r.MaxRedemptions, r.MaxRedemptionsPerCustomer
+}
+
+// Restricted reports whether the rule only applies to the customers and
+// segments it lists.
+func (r *CouponRule) Restricted() bool {
+	return len(r.Customers) > 0 || len(r.Segments) > 0
+}
+
+func (r CouponRule) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Name, validation.Required),
+		validation.Field(&r.Type, validation.Required, validation.By(func(value interface{}) error {
+			v, _ := value.(constants.DiscountType)
+			if !v.IsValid() {
+				return fmt.Errorf("invalid discount type: %s", v)
+			}
+			return nil
+		})),
+		validation.Field(&r.Value,
+			validation.When(r.Type == constants.PercentageDiscount, validation.Required, validation.Min(0.01), validation.Max(100.0)),
+			validation.When(r.Type == constants.FixedDiscount, validation.Required, validation.Min(0.01)),
+		),
+		validation.Field(&r.BuyQuantity, validation.When(r.Type == constants.BuyXGetYDiscount, validation.Required, validation.Min(1))),
+		validation.Field(&r.GetQuantity, validation.When(r.Type == constants.BuyXGetYDiscount, validation.Required, validation.Min(1))),
+		validation.Field(&r.MinBasketTotal, validation.Min(0.0)),
+		validation.Field(&r.MaxDiscount, validation.Min(0.0)),
+		validation.Field(&r.MaxRedemptions, validation.Min(int64(0))),
+		validation.Field(&r.MaxRedemptionsPerCustomer, validation.Min(int64(0))),
+		validation.Field(&r.ValidUntil, validation.By(func(value interface{}) error {
+			until, _ := value.(*time.Time)
+			if until != nil && r.ValidFrom != nil && !until.After(*r.ValidFrom) {
+				return fmt.Errorf("validUntil must be after validFrom")
+			}
+			return nil
+		})),
+	)
+}
+
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	return scanJSONB(value, l)
+}
+
+type Int64List []int64
+
+func (l Int64List) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *Int64List) Scan(value interface{}) error {
+	return scanJSONB(value, l)
+}
+
+func scanJSONB(value interface{}, dest any) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("failed to scan JSONB: unexpected type %T", value)
+	}
+
+	if err := json.Unmarshal(bytes, dest); err != nil {
+		return fmt.Errorf("failed to unmarshal JSONB: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package ingress
+
+import (
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+)
+
+// CustomerSegment places a customer in a segment coupon rules can be
+// restricted to.
+type CustomerSegment struct {
+	CustomerID string `json:"customerId" gorm:"primaryKey"`
+	Segment    string `json:"segment" gorm:"primaryKey;index"`
+}
+
+// CouponRedemption records a coupon redeemed by a customer, and is the line of
+// its order itemising the discount the code granted. It is stored with its
+// order, in the same transaction.
+type CouponRedemption struct {
+	ID         int64                  `json:"id" gorm:"primaryKey;autoIncrement"`
+	OrderID    int64                  `json:"orderId" gorm:"not null;uniqueIndex:idx_coupon_redemptions_order_code,priority:1"`
+	CustomerID string                 `json:"customerId" gorm:"not null;index:idx_coupon_redemptions_customer,priority:1"`
+	CouponCode string                 `json:"couponCode" gorm:"not null;index;uniqueIndex:idx_coupon_redemptions_order_code,priority:2"`
+	CouponRule string                 `json:"couponRule" gorm:"not null"`
+	Type       constants.DiscountType `json:"type" gorm:"not null"`
+	Discount   float64                `json:"discount" gorm:"not null"`
+	CreatedAt  time.Time              `json:"createdAt" gorm:"autoCreateTime;index:idx_coupon_redemptions_customer,priority:2"`
+}
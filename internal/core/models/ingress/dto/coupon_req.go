@@ -0,0 +1,54 @@
+package dto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+type AddCouponsReq struct {
+	Codes []string `json:"codes"`
+}
+
+func (a *AddCouponsReq) Sanitize(step constants.ProcesssStep) {
+	for i, code := range a.Codes {
+		a.Codes[i] = utils.Sanitize(code)
+	}
+}
+
+// Normalize brings the codes to the canonical form of cfg.
+func (a *AddCouponsReq) Normalize(cfg *models.CouponValidator) {
+	for i, code := range a.Codes {
+		a.Codes[i] = utils.NormalizeCode(code, cfg)
+	}
+}
+
+func (a AddCouponsReq) Validate(cfg *models.CouponValidator) error {
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Codes, validation.Required, validation.Length(1, 1000), validation.Each(validation.By(func(value interface{}) error {
+			code, _ := value.(string)
+			if !utils.ValidateCode(code, cfg) {
+				return fmt.Errorf("invalid coupon code")
+			}
+			return nil
+		}))),
+	)
+}
+
+type RevokeCouponReq struct {
+	Reason string `json:"reason"`
+}
+
+func (r *RevokeCouponReq) Sanitize(step constants.ProcesssStep) {
+	r.Reason = strings.TrimSpace(r.Reason)
+}
+
+func (r RevokeCouponReq) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Reason, validation.Length(0, 256)),
+	)
+}
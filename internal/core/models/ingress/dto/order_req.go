@@ -9,24 +9,91 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
+// OrderReq is the payload of an order. Coupon codes are sent in couponCodes,
+// couponCode being kept for clients sending a single code.
 type OrderReq struct {
-	Items      []ItemReq `json:"items"`
-	CouponCode string    `json:"couponCode"`
+	Items       []ItemReq `json:"items"`
+	CouponCode  string    `json:"couponCode"`
+	CouponCodes []string  `json:"couponCodes"`
+	CustomerID  string    `json:"customerId"`
 }
 
 func (o *OrderReq) Sanitize(step constants.ProcesssStep) {
 	o.CouponCode = utils.Sanitize(o.CouponCode)
+	for i := range o.CouponCodes {
+		o.CouponCodes[i] = utils.Sanitize(o.CouponCodes[i])
+	}
+	o.CustomerID = utils.Sanitize(o.CustomerID)
+}
+
+// Normalize brings the coupon codes to the canonical form of cfg, the form
+// they are validated and looked up in.
+func (o *OrderReq) Normalize(cfg *models.CouponValidator) {
+	o.CouponCode = utils.NormalizeCode(o.CouponCode, cfg)
+	for i := range o.CouponCodes {
+		o.CouponCodes[i] = utils.NormalizeCode(o.CouponCodes[i], cfg)
+	}
+}
+
+// Codes returns the coupon codes of the order in the order they were sent,
+// couponCode first, without repeats.
+func (o *OrderReq) Codes() []string {
+	codes := make([]string, 0, len(o.CouponCodes)+1)
+	seen := make(map[string]bool, len(o.CouponCodes)+1)
+	for _, code := range append([]string{o.CouponCode}, o.CouponCodes...) {
+		if code == "" || seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// MergeItems folds the lines of a product into its first line, adding up their
+// quantities, and reports whether any line was merged.
+func (o *OrderReq) MergeItems() bool {
+	items := make([]ItemReq, 0, len(o.Items))
+	lines := make(map[int64]int, len(o.Items))
+	for _, item := range o.Items {
+		if i, ok := lines[item.ProductID]; ok {
+			items[i].Quantity += item.Quantity
+			continue
+		}
+		lines[item.ProductID] = len(items)
+		items = append(items, item)
+	}
+
+	merged := len(items) != len(o.Items)
+	o.Items = items
+	return merged
+}
+
+// ClearCoupons drops every coupon code of the order.
+func (o *OrderReq) ClearCoupons() {
+	o.CouponCode = ""
+	o.CouponCodes = nil
 }
 
 func (or OrderReq) Validate(cfg *models.CouponValidator) error {
+	validCode := validation.By(func(value interface{}) error {
+		code := value.(string)
+		if code != "" {
+			if !utils.ValidateCode(code, cfg) {
+				return fmt.Errorf("invalid coupon code")
+			}
+		}
+		return nil
+	})
+
 	return validation.ValidateStruct(&or,
 		validation.Field(&or.Items, validation.Required, validation.Length(1, 0)),
-		validation.Field(&or.CouponCode, validation.By(func(value interface{}) error {
-			code := value.(string)
-			if code != "" {
-				if !utils.ValidateCode(code, cfg) {
-					return fmt.Errorf("invalid coupon code")
-				}
+		validation.Field(&or.CouponCode, validCode),
+		validation.Field(&or.CouponCodes, validation.Length(0, constants.MaxOrderCouponCodes), validation.Each(validation.Required, validCode)),
+		validation.Field(&or.CustomerID, validation.By(func(value interface{}) error {
+			id := value.(string)
+			if id != "" && !utils.ValidateCustomerID(id) {
+				return fmt.Errorf("invalid customer id")
 			}
 			return nil
 		})),
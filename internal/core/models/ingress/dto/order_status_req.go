@@ -0,0 +1,32 @@
+package dto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// OrderStatusReq moves an order to Status, optionally saying why.
+type OrderStatusReq struct {
+	Status constants.OrderStatus `json:"status"`
+	Reason string                `json:"reason"`
+}
+
+func (o *OrderStatusReq) Sanitize(step constants.ProcesssStep) {
+	o.Status = constants.OrderStatus(strings.ToLower(strings.TrimSpace(o.Status.String())))
+	o.Reason = strings.TrimSpace(o.Reason)
+}
+
+func (o OrderStatusReq) Validate() error {
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.Status, validation.Required, validation.By(func(value interface{}) error {
+			if status, _ := value.(constants.OrderStatus); !status.IsValid() {
+				return fmt.Errorf("invalid order status")
+			}
+			return nil
+		})),
+		validation.Field(&o.Reason, validation.Length(0, 256)),
+	)
+}
@@ -1,10 +1,20 @@
 package ingress
 
+// Item is a line of an order. The product name and prices are those at the
+// time the order was placed; Discount is the share of the order's coupon
+// discounts taken off the line.
 type Item struct {
 	ID int64 `json:"id" gorm:"primaryKey;autoIncrement"`
 
-	ProductID int64 `json:"productId" gorm:"not null"`
-	Quantity  int   `json:"quantity" gorm:"not null"`
+	ProductID   int64   `json:"productId" gorm:"not null"`
+	ProductName string  `json:"productName" gorm:"not null;default:''"`
+	UnitPrice   float64 `json:"unitPrice" gorm:"not null;default:0"`
+	Quantity    int     `json:"quantity" gorm:"not null"`
+	Subtotal    float64 `json:"subtotal" gorm:"not null;default:0"`
+	Discount    float64 `json:"discount" gorm:"not null;default:0"`
+
+	// Product is filled in when an order is read back.
+	Product *Product `json:"product,omitempty" gorm:"-"`
 
 	OrderID int64 `json:"orderId" gorm:"not null;index"`
 	Order   Order `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
@@ -1,13 +1,26 @@
 package ingress
 
-import "time"
+import (
+	"time"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+)
+
+// Order is a placed order. Discount is the sum of the discounts itemised in
+// Discounts, one per applied coupon code. ClientID identifies the API key that
+// placed it, the only one it is served back to. StatusHistory records every
+// status the order went through.
 type Order struct {
-	Id         int64    `json:"id" gorm:"primaryKey;autoIncrement"`
-	Total      float64  `json:"total" gorm:"not null"`
-	Discounts  *float64 `json:"discounts,omitempty"`
-	CouponCode string   `json:"couponCode,omitempty"`
+	Id         int64   `json:"id" gorm:"primaryKey;autoIncrement"`
+	Total      float64 `json:"total" gorm:"not null"`
+	Discount   float64 `json:"discount"`
+	CustomerID string  `json:"customerId,omitempty" gorm:"index"`
+	ClientID   string  `json:"-" gorm:"index"`
+
+	Status        constants.OrderStatus `json:"status" gorm:"not null;default:placed;index"`
+	StatusHistory []OrderStatusChange   `json:"statusHistory,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 
-	Items     []Item    `json:"items" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
-	CreatedAt time.Time `json:"createdAt" gorm:"autoCreateTime"`
+	Items     []Item             `json:"items" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Discounts []CouponRedemption `json:"discounts,omitempty" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt time.Time          `json:"createdAt" gorm:"autoCreateTime"`
 }
@@ -0,0 +1,19 @@
+package ingress
+
+import (
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+)
+
+// OrderStatusChange records an order moving from one status to another. The
+// first change of an order, with no FromStatus, is its placing.
+type OrderStatusChange struct {
+	ID         int64                 `json:"id" gorm:"primaryKey;autoIncrement"`
+	OrderID    int64                 `json:"orderId" gorm:"not null;index"`
+	FromStatus constants.OrderStatus `json:"from,omitempty"`
+	ToStatus   constants.OrderStatus `json:"to" gorm:"not null"`
+	Reason     string                `json:"reason,omitempty"`
+	ChangedBy  string                `json:"changedBy,omitempty"`
+	CreatedAt  time.Time             `json:"createdAt" gorm:"autoCreateTime"`
+}
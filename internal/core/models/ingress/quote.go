@@ -0,0 +1,44 @@
+package ingress
+
+import "github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+
+// OrderQuote is an order request priced without being placed. Coupon codes
+// that would not be applied are listed in RejectedCoupons with the reason, and
+// the totals are those of the order without them.
+type OrderQuote struct {
+	CustomerID      string            `json:"customerId,omitempty"`
+	Lines           []QuoteLine       `json:"lines"`
+	Subtotal        float64           `json:"subtotal"`
+	Discount        float64           `json:"discount"`
+	Total           float64           `json:"total"`
+	Discounts       []QuoteDiscount   `json:"discounts,omitempty"`
+	RejectedCoupons []CouponRejection `json:"rejectedCoupons,omitempty"`
+}
+
+// QuoteLine is an order line of a quote. Discount is the share of the coupon
+// discounts taken off the line.
+type QuoteLine struct {
+	ProductID int64   `json:"productId"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category"`
+	UnitPrice float64 `json:"unitPrice"`
+	Quantity  int     `json:"quantity"`
+	Subtotal  float64 `json:"subtotal"`
+	Discount  float64 `json:"discount"`
+	Total     float64 `json:"total"`
+}
+
+// QuoteDiscount is the discount a coupon code would grant.
+type QuoteDiscount struct {
+	CouponCode string                 `json:"couponCode"`
+	CouponRule string                 `json:"couponRule"`
+	Type       constants.DiscountType `json:"type"`
+	Discount   float64                `json:"discount"`
+}
+
+// CouponRejection is a coupon code of a quote that would not be applied.
+type CouponRejection struct {
+	CouponCode string              `json:"couponCode"`
+	Code       constants.ErrorCode `json:"code"`
+	Error      string              `json:"error"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+)
+
+// OrderFilter selects the orders of an API key, most recent first. AfterID is
+// the cursor: only orders placed before the order with that ID are returned.
+// From is inclusive and To exclusive; zero times, an empty Status and an empty
+// CouponCode do not filter.
+type OrderFilter struct {
+	ClientID   string
+	Status     constants.OrderStatus
+	CouponCode string
+	From       time.Time
+	To         time.Time
+	AfterID    int64
+	Limit      int
+}
+
+// IdempotencyRecord is kept under an Idempotency-Key: the hash of the request
+// that first used the key and, once it was handled, its response. Status is
+// zero while the request is being handled.
+type IdempotencyRecord struct {
+	RequestHash string `json:"requestHash"`
+	Status      int    `json:"status,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
@@ -2,6 +2,9 @@ package egress
 
 import (
 	"context"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -12,7 +15,34 @@ type CacheConnectionPorts interface {
 }
 
 type CacheRepository interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
 	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SIsMember(ctx context.Context, key string, member string) (bool, error)
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SScan(ctx context.Context, key string, cursor uint64, count int64) ([]string, uint64, error)
+	SCard(ctx context.Context, key string) (int64, error)
+	HSet(ctx context.Context, key, field string, value interface{}) error
+	HGet(ctx context.Context, key, field string) (string, error)
+	HExists(ctx context.Context, key, field string) (bool, error)
+	HDel(ctx context.Context, key string, fields ...string) (int64, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HKeys(ctx context.Context, key string) ([]string, error)
 	BFExists(ctx context.Context, filterName string, value string) (bool, error)
+	BFMAdd(ctx context.Context, filterName string, values ...string) error
+	BFReserve(ctx context.Context, filterName string, errorRate float64, capacity uint64, expansion int) error
+	BFInfo(ctx context.Context, filterName string) (*models.BloomInfo, error)
+	BFSave(ctx context.Context, filterName string) error
 	Do(ctx context.Context, args []interface{}) error
+	RedeemCoupon(ctx context.Context, totalKey, customerKey string, maxTotal, maxPerCustomer int64) error
+	ReleaseCoupon(ctx context.Context, totalKey, customerKey string) error
+	Incr(ctx context.Context, key string) (int64, error)
+	AcquireLock(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	RenewLock(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, key, value string) error
+	SetIfLocked(ctx context.Context, fence models.CouponLeaseFence, key string, value interface{}) (bool, error)
+	CompareAndSet(ctx context.Context, key, expected string, value interface{}, ttl time.Duration) (bool, error)
+	AddCodesIfLocked(ctx context.Context, fence models.CouponLeaseFence, bloomKey, exactKey string, codes []string) (bool, error)
 }
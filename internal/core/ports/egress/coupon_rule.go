@@ -0,0 +1,12 @@
+package egress
+
+import (
+	"context"
+
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+)
+
+type CouponRuleRepository interface {
+	ListCouponRules(ctx context.Context) ([]ingressModels.CouponRule, error)
+	ListCustomerSegments(ctx context.Context) ([]ingressModels.CustomerSegment, error)
+}
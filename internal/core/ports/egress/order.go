@@ -3,9 +3,14 @@ package egress
 import (
 	"context"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
 )
 
 type OrderRepository interface {
 	CreateOrder(ctx context.Context, payload *ingressModels.Order) error
+	GetOrder(ctx context.Context, id int64) (*ingressModels.Order, error)
+	ListOrders(ctx context.Context, filter models.OrderFilter) ([]ingressModels.Order, error)
+	UpdateOrderStatus(ctx context.Context, id int64, change *ingressModels.OrderStatusChange) error
+	ListRedemptions(ctx context.Context, customerID, couponCode string, limit int) ([]ingressModels.CouponRedemption, error)
 }
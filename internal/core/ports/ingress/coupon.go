@@ -0,0 +1,31 @@
+package ingress
+
+import (
+	"context"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+
+	"github.com/valyala/fasthttp"
+)
+
+type CouponServicePorts interface {
+	Verify(ctx context.Context, code string) (bool, error)
+	IsRevoked(ctx context.Context, code string) (bool, error)
+	Stats() models.CouponVerificationStats
+	LoadRules(ctx context.Context) error
+	Rules(code string) []ingressModels.CouponRule
+	Segments(customerID string) []string
+	Redeem(ctx context.Context, code, customerID string, rule *ingressModels.CouponRule) error
+	CheckRedemption(ctx context.Context, code, customerID string, rule *ingressModels.CouponRule) error
+	Release(ctx context.Context, code, customerID string) error
+	ActiveDataset(ctx context.Context) (*models.CouponDataset, error)
+	AddCodes(ctx context.Context, version int64, codes []string) error
+	AddCodesIfLocked(ctx context.Context, version int64, fence models.CouponLeaseFence, codes []string) (bool, error)
+	Revoke(ctx context.Context, code, reason, revokedBy string) (*models.CouponRevocation, error)
+	Reinstate(ctx context.Context, code string) (*models.CouponRevocation, error)
+	Revocation(ctx context.Context, code string) (*models.CouponRevocation, error)
+	Revocations(ctx context.Context) ([]models.CouponRevocation, error)
+	Redemptions(ctx context.Context, code string) (int64, error)
+	BloomInfo(ctx *fasthttp.RequestCtx)
+}
@@ -0,0 +1,12 @@
+package ingress
+
+import "github.com/valyala/fasthttp"
+
+type CouponAdminServicePorts interface {
+	AddCoupons(ctx *fasthttp.RequestCtx)
+	UploadCoupons(ctx *fasthttp.RequestCtx)
+	RevokeCoupon(ctx *fasthttp.RequestCtx)
+	ReinstateCoupon(ctx *fasthttp.RequestCtx)
+	ListRevocations(ctx *fasthttp.RequestCtx)
+	CouponStatus(ctx *fasthttp.RequestCtx)
+}
@@ -3,4 +3,6 @@ package ingress
 type HandlerPorts interface {
 	SetProductHandler(productServicePorts ProductServicePorts)
 	SetOrderHandler(orderServicePorts OrderServicePorts)
+	SetReadinessHandler(readinessServicePorts ReadinessServicePorts)
+	SetCouponHandler(couponServicePorts CouponServicePorts, couponAdminServicePorts CouponAdminServicePorts)
 }
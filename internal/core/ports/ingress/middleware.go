@@ -5,6 +5,8 @@ import "github.com/valyala/fasthttp"
 type MiddlewarePorts interface {
 	RequestId(next fasthttp.RequestHandler) fasthttp.RequestHandler
 	Authorization(next fasthttp.RequestHandler) fasthttp.RequestHandler
+	AdminAuthorization(next fasthttp.RequestHandler) fasthttp.RequestHandler
 	PanicRecover(next fasthttp.RequestHandler) fasthttp.RequestHandler
 	EnsureJSON(next fasthttp.RequestHandler) fasthttp.RequestHandler
+	LimitBody(next fasthttp.RequestHandler) fasthttp.RequestHandler
 }
@@ -4,4 +4,10 @@ import "github.com/valyala/fasthttp"
 
 type OrderServicePorts interface {
 	CreateOrder(ctx *fasthttp.RequestCtx)
+	QuoteOrder(ctx *fasthttp.RequestCtx)
+	GetOrder(ctx *fasthttp.RequestCtx)
+	ListOrders(ctx *fasthttp.RequestCtx)
+	UpdateOrderStatus(ctx *fasthttp.RequestCtx)
+	StaffUpdateOrderStatus(ctx *fasthttp.RequestCtx)
+	ListRedemptions(ctx *fasthttp.RequestCtx)
 }
@@ -0,0 +1,20 @@
+package ingress
+
+import (
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+
+	"github.com/valyala/fasthttp"
+)
+
+type ReadinessServicePorts interface {
+	Readyz(ctx *fasthttp.RequestCtx)
+	CouponsReady() bool
+	CouponStatus() models.CouponIngestionStatus
+	StartCouponLoad(files int)
+	UpdateCouponProgress(completedBatches, codesLoaded int64)
+	FinishCouponLoad(err error)
+	RetryCouponLoad(at time.Time)
+	SetCouponStats(stats func() models.CouponVerificationStats)
+}
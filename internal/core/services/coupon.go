@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
+	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	ingressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+type couponService struct {
+	config               *models.Config
+	logger               ports.LoggerPorts
+	cacheRepository      egressPorts.CacheRepository
+	couponRuleRepository egressPorts.CouponRuleRepository
+
+	rulesMu            sync.RWMutex
+	rulesByCode        map[string][]ingressModels.CouponRule
+	defaultRules       []ingressModels.CouponRule
+	segmentsByCustomer map[string][]string
+
+	checks         atomic.Uint64
+	bloomHits      atomic.Uint64
+	exactHits      atomic.Uint64
+	falsePositives atomic.Uint64
+}
+
+func NewCouponService(config *models.Config, logger ports.LoggerPorts, cacheRepository egressPorts.CacheRepository, couponRuleRepository egressPorts.CouponRuleRepository) ingressPorts.CouponServicePorts {
+	return &couponService{
+		config:               config,
+		logger:               logger,
+		cacheRepository:      cacheRepository,
+		couponRuleRepository: couponRuleRepository,
+	}
+}
+
+// Verify reports whether code is a known coupon of the active dataset. In
+// two-tier mode the Bloom filter acts as a fast negative filter and every hit
+// is confirmed against the exact set, so Bloom false positives are never
+// accepted. Revocations are checked separately, see IsRevoked.
+func (c *couponService) Verify(ctx context.Context, code string) (bool, error) {
+	c.checks.Add(1)
+	cfg := c.config.CouponConfig
+
+	bloomKey, exactKey, err := c.activeKeys(ctx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if cfg.VerificationMode != constants.ExactOnly {
+		exists, err := c.cacheRepository.BFExists(ctx, bloomKey, code)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+		c.bloomHits.Add(1)
+
+		if cfg.VerificationMode == constants.BloomOnly {
+			return true, nil
+		}
+	}
+
+	exists, err := c.cacheRepository.SIsMember(ctx, exactKey, code)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if cfg.VerificationMode == constants.TwoTier {
+			c.falsePositives.Add(1)
+			stats := c.Stats()
+			c.logger.Warn("bloom filter false positive",
+				zap.String("coupon", code),
+				zap.Uint64("falsePositives", stats.FalsePositives),
+				zap.Float64("falsePositiveRate", stats.FalsePositiveRate),
+			)
+		}
+		return false, nil
+	}
+	c.exactHits.Add(1)
+
+	return true, nil
+}
+
+// IsRevoked reports whether code is on the revocation list. Bloom filters
+// cannot delete entries, so a revoked code stays a member of the dataset until
+// it is compacted and must be checked here before it is accepted.
+func (c *couponService) IsRevoked(ctx context.Context, code string) (bool, error) {
+	return c.cacheRepository.HExists(ctx, c.config.CouponConfig.RevokedKey, code)
+}
+
+// activeKeys resolves the keys of the active dataset version. Both tiers are
+// checked against the same version even if the pointer flips mid-request.
+func (c *couponService) activeKeys(ctx context.Context) (string, string, error) {
+	dataset, err := c.ActiveDataset(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	bloomKey, exactKey := c.config.CouponConfig.DatasetKeys(dataset.Version)
+	return bloomKey, exactKey, nil
+}
+
+// ActiveDataset returns the dataset version currently served to orders.
+func (c *couponService) ActiveDataset(ctx context.Context) (*models.CouponDataset, error) {
+	raw, err := c.cacheRepository.Get(ctx, c.config.CouponConfig.Versions.ActiveKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataset models.CouponDataset
+	if err := json.Unmarshal([]byte(raw), &dataset); err != nil {
+		return nil, fmt.Errorf("failed to decode coupon dataset: %w", err)
+	}
+	return &dataset, nil
+}
+
+// BloomInfo reports the BF.INFO stats of the Bloom filter of the active
+// dataset.
+func (c *couponService) BloomInfo(ctx *fasthttp.RequestCtx) {
+	dataset, err := c.ActiveDataset(ctx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.SetBodyString(`{"error":"no active coupon dataset"}`)
+			return
+		}
+		c.logger.Error("failed to read active coupon dataset", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to read coupon dataset"}`)
+		return
+	}
+
+	bloomKey, _ := c.config.CouponConfig.DatasetKeys(dataset.Version)
+	info, err := c.cacheRepository.BFInfo(ctx, bloomKey)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.SetBodyString(`{"error":"bloom filter not found"}`)
+			return
+		}
+		c.logger.Error("failed to read bloom filter info", zap.String("key", bloomKey), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to read bloom filter info"}`)
+		return
+	}
+
+	responseBody, err := json.Marshal(map[string]any{
+		"backend": c.config.CouponConfig.Bloom.Backend,
+		"key":     bloomKey,
+		"version": dataset.Version,
+		"info":    info,
+		"stats":   c.Stats(),
+	})
+	if err != nil {
+		c.logger.Error("failed to marshal bloom info response", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+// AddCodes writes codes to the Bloom filter and exact set of a dataset
+// version.
+func (c *couponService) AddCodes(ctx context.Context, version int64, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	bloomKey, exactKey := c.config.CouponConfig.DatasetKeys(version)
+
+	if err := c.cacheRepository.BFMAdd(ctx, bloomKey, codes...); err != nil {
+		return fmt.Errorf("failed to add to bloom filter: %w", err)
+	}
+
+	members := make([]interface{}, len(codes))
+	for i, code := range codes {
+		members[i] = code
+	}
+
+	if err := c.cacheRepository.SAdd(ctx, exactKey, members...); err != nil {
+		return fmt.Errorf("redis SADD error: %w", err)
+	}
+
+	return nil
+}
+
+// AddCodesIfLocked writes codes to the Bloom filter and exact set of a dataset
+// version, in one step, only while the ingestion lease is held under fence.
+func (c *couponService) AddCodesIfLocked(ctx context.Context, version int64, fence models.CouponLeaseFence, codes []string) (bool, error) {
+	if len(codes) == 0 {
+		return true, nil
+	}
+
+	bloomKey, exactKey := c.config.CouponConfig.DatasetKeys(version)
+	return c.cacheRepository.AddCodesIfLocked(ctx, fence, bloomKey, exactKey, codes)
+}
+
+// Revoke adds code to the revocation list. Revoking a code again replaces the
+// recorded reason and timestamp.
+func (c *couponService) Revoke(ctx context.Context, code, reason, revokedBy string) (*models.CouponRevocation, error) {
+	revocation := &models.CouponRevocation{
+		Code:      code,
+		Reason:    reason,
+		RevokedBy: revokedBy,
+		RevokedAt: time.Now().UTC(),
+	}
+
+	raw, err := json.Marshal(revocation)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cacheRepository.HSet(ctx, c.config.CouponConfig.RevokedKey, code, raw); err != nil {
+		return nil, err
+	}
+	return revocation, nil
+}
+
+// Reinstate removes code from the revocation list and returns the revocation
+// it lifted, or utils.ErrNoData when code is not revoked. A code dropped from
+// the dataset by compaction is only accepted again once it is reloaded or
+// re-added.
+func (c *couponService) Reinstate(ctx context.Context, code string) (*models.CouponRevocation, error) {
+	revocation, err := c.Revocation(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := c.cacheRepository.HDel(ctx, c.config.CouponConfig.RevokedKey, code)
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, utils.ErrNoData
+	}
+	return revocation, nil
+}
+
+// Revocation returns the revocation of code, or utils.ErrNoData when code is
+// not revoked.
+func (c *couponService) Revocation(ctx context.Context, code string) (*models.CouponRevocation, error) {
+	raw, err := c.cacheRepository.HGet(ctx, c.config.CouponConfig.RevokedKey, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var revocation models.CouponRevocation
+	if err := json.Unmarshal([]byte(raw), &revocation); err != nil {
+		return nil, fmt.Errorf("failed to decode coupon revocation: %w", err)
+	}
+	return &revocation, nil
+}
+
+// Revocations returns the revocation list, most recent first.
+func (c *couponService) Revocations(ctx context.Context) ([]models.CouponRevocation, error) {
+	entries, err := c.cacheRepository.HGetAll(ctx, c.config.CouponConfig.RevokedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := make([]models.CouponRevocation, 0, len(entries))
+	for code, raw := range entries {
+		var revocation models.CouponRevocation
+		if err := json.Unmarshal([]byte(raw), &revocation); err != nil {
+			return nil, fmt.Errorf("failed to decode revocation of %q: %w", code, err)
+		}
+		revocations = append(revocations, revocation)
+	}
+
+	sort.Slice(revocations, func(i, j int) bool {
+		if revocations[i].RevokedAt.Equal(revocations[j].RevokedAt) {
+			return revocations[i].Code < revocations[j].Code
+		}
+		return revocations[i].RevokedAt.After(revocations[j].RevokedAt)
+	})
+	return revocations, nil
+}
+
+// Redemptions returns how many times code has been redeemed.
+func (c *couponService) Redemptions(ctx context.Context, code string) (int64, error) {
+	totalKey, _ := c.redemptionKeys(code, "")
+	return c.redemptionCount(ctx, totalKey)
+}
+
+func (c *couponService) redemptionCount(ctx context.Context, key string) (int64, error) {
+	raw, err := c.cacheRepository.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+func (c *couponService) Stats() models.CouponVerificationStats {
+	stats := models.CouponVerificationStats{
+		Mode:           c.config.CouponConfig.VerificationMode,
+		Checks:         c.checks.Load(),
+		BloomHits:      c.bloomHits.Load(),
+		ExactHits:      c.exactHits.Load(),
+		FalsePositives: c.falsePositives.Load(),
+	}
+	if stats.BloomHits > 0 {
+		stats.FalsePositiveRate = float64(stats.FalsePositives) / float64(stats.BloomHits)
+	}
+	return stats
+}
+
+// Redeem atomically records a redemption of code by customerID, enforcing the
+// global and per-customer limits of rule.
+func (c *couponService) Redeem(ctx context.Context, code, customerID string, rule *ingressModels.CouponRule) error {
+	maxTotal, maxPerCustomer := rule.RedemptionLimits()
+	totalKey, customerKey := c.redemptionKeys(code, customerID)
+	return c.cacheRepository.RedeemCoupon(ctx, totalKey, customerKey, maxTotal, maxPerCustomer)
+}
+
+// CheckRedemption tells, without recording anything, whether Redeem would
+// currently accept a redemption of code by customerID under rule.
+func (c *couponService) CheckRedemption(ctx context.Context, code, customerID string, rule *ingressModels.CouponRule) error {
+	maxTotal, maxPerCustomer := rule.RedemptionLimits()
+	totalKey, customerKey := c.redemptionKeys(code, customerID)
+
+	if maxTotal > 0 {
+		total, err := c.redemptionCount(ctx, totalKey)
+		if err != nil {
+			return err
+		}
+		if total >= maxTotal {
+			return utils.ErrCouponExhausted
+		}
+	}
+	if maxPerCustomer > 0 && customerKey != "" {
+		redeemed, err := c.redemptionCount(ctx, customerKey)
+		if err != nil {
+			return err
+		}
+		if redeemed >= maxPerCustomer {
+			return utils.ErrCouponAlreadyRedeemed
+		}
+	}
+	return nil
+}
+
+// Release reverts a redemption recorded by Redeem.
+func (c *couponService) Release(ctx context.Context, code, customerID string) error {
+	totalKey, customerKey := c.redemptionKeys(code, customerID)
+	return c.cacheRepository.ReleaseCoupon(ctx, totalKey, customerKey)
+}
+
+// redemptionKeys returns the global and per-customer counter keys of code. The
+// customer key is empty when there is no customer identity (API keys
+// disabled), so per-customer limits are skipped rather than shared by every
+// caller.
+func (c *couponService) redemptionKeys(code, customerID string) (string, string) {
+	totalKey := fmt.Sprintf("%s:%s", c.config.CouponConfig.RedemptionKey, code)
+	if customerID == "" {
+		return totalKey, ""
+	}
+	return totalKey, fmt.Sprintf("%s:%s", totalKey, customerID)
+}
+
+// LoadRules (re)loads the discount rules from the configured source.
+func (c *couponService) LoadRules(ctx context.Context) error {
+	var rules []ingressModels.CouponRule
+
+	switch c.config.CouponConfig.Rules.Source {
+	case constants.RuleSourceDatabase:
+		dbRules, err := c.couponRuleRepository.ListCouponRules(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load coupon rules: %w", err)
+		}
+		for _, rule := range dbRules {
+			if err := rule.Validate(); err != nil {
+				return fmt.Errorf("invalid coupon rule %q: %w", rule.Name, err)
+			}
+			if rule.Restricted() && !c.config.ApiKey.Enabled {
+				return fmt.Errorf("coupon rule %q is restricted to customers or segments, which requires apiKey.enabled", rule.Name)
+			}
+		}
+		rules = dbRules
+	default:
+		rules = append(rules, c.config.CouponConfig.Rules.Definitions...)
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+
+	segmentsByCustomer, err := c.loadSegments(ctx)
+	if err != nil {
+		return err
+	}
+
+	rulesByCode := make(map[string][]ingressModels.CouponRule)
+	defaultRules := make([]ingressModels.CouponRule, 0)
+	for _, rule := range rules {
+		if len(rule.Codes) == 0 {
+			defaultRules = append(defaultRules, rule)
+			continue
+		}
+		for _, code := range rule.Codes {
+			rulesByCode[code] = append(rulesByCode[code], rule)
+		}
+	}
+
+	c.rulesMu.Lock()
+	c.rulesByCode = rulesByCode
+	c.defaultRules = defaultRules
+	c.segmentsByCustomer = segmentsByCustomer
+	c.rulesMu.Unlock()
+
+	c.logger.Info("coupon rules loaded",
+		zap.String("source", c.config.CouponConfig.Rules.Source.String()),
+		zap.Int("rules", len(rules)),
+		zap.Int("segmentedCustomers", len(segmentsByCustomer)),
+	)
+	return nil
+}
+
+// loadSegments reads the customer segments from the rules source, as customer
+// ID to segment names.
+func (c *couponService) loadSegments(ctx context.Context) (map[string][]string, error) {
+	segmentsByCustomer := make(map[string][]string)
+
+	switch c.config.CouponConfig.Rules.Source {
+	case constants.RuleSourceDatabase:
+		segments, err := c.couponRuleRepository.ListCustomerSegments(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load customer segments: %w", err)
+		}
+		for _, segment := range segments {
+			segmentsByCustomer[segment.CustomerID] = append(segmentsByCustomer[segment.CustomerID], segment.Segment)
+		}
+	default:
+		for segment, customers := range c.config.CouponConfig.Rules.Segments {
+			for _, customerID := range customers {
+				segmentsByCustomer[customerID] = append(segmentsByCustomer[customerID], segment)
+			}
+		}
+	}
+
+	return segmentsByCustomer, nil
+}
+
+// Segments returns the segments customerID belongs to.
+func (c *couponService) Segments(customerID string) []string {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
+	return c.segmentsByCustomer[customerID]
+}
+
+// Rules returns the candidate rules for code in evaluation order: rules bound
+// to the code first, then the catch-all rules, each group by priority.
+func (c *couponService) Rules(code string) []ingressModels.CouponRule {
+	c.rulesMu.RLock()
+	defer c.rulesMu.RUnlock()
+
+	rules := make([]ingressModels.CouponRule, 0, len(c.rulesByCode[code])+len(c.defaultRules))
+	rules = append(rules, c.rulesByCode[code]...)
+	rules = append(rules, c.defaultRules...)
+	return rules
+}
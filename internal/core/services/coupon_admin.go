@@ -0,0 +1,427 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress/dto"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
+	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	ingressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+var errNoActiveDataset = errors.New("no active coupon dataset")
+
+type couponAdminService struct {
+	config             *models.Config
+	logger             ports.LoggerPorts
+	cacheRepository    egressPorts.CacheRepository
+	couponServicePorts ingressPorts.CouponServicePorts
+}
+
+func NewCouponAdminService(config *models.Config, logger ports.LoggerPorts, cacheRepository egressPorts.CacheRepository, couponServicePorts ingressPorts.CouponServicePorts) ingressPorts.CouponAdminServicePorts {
+	return &couponAdminService{
+		config:             config,
+		logger:             logger,
+		cacheRepository:    cacheRepository,
+		couponServicePorts: couponServicePorts,
+	}
+}
+
+// AddCoupons adds the codes of the request to the active dataset.
+func (a *couponAdminService) AddCoupons(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("AddCoupons"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	var payload dto.AddCouponsReq
+	if err := json.Unmarshal(ctx.PostBody(), &payload); err != nil {
+		logger.Error("invalid request payload", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"invalid request payload"}`)
+		return
+	}
+
+	payload.Sanitize(constants.ADD)
+	payload.Normalize(a.config.CouponConfig.Validation)
+	if err := payload.Validate(a.config.CouponConfig.Validation); err != nil {
+		logger.Error("validation failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+
+	version, ok := a.activeVersion(ctx, logger)
+	if !ok {
+		return
+	}
+
+	if err := a.addCodes(ctx, version, payload.Codes); err != nil {
+		logger.Error("failed to add coupon codes", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to add coupon codes"}`)
+		return
+	}
+	if err := a.saveBloom(ctx, version); err != nil {
+		logger.Error("failed to save bloom filter", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to add coupon codes"}`)
+		return
+	}
+
+	logger.Info("coupon codes added", zap.Int("codes", len(payload.Codes)), zap.Int64("version", version))
+	a.writeJSON(ctx, logger, fasthttp.StatusCreated, map[string]any{
+		"version": version,
+		"added":   len(payload.Codes),
+	})
+}
+
+// UploadCoupons adds the codes of an uploaded file, one code per line, to the
+// active dataset. The file is sent either as the "file" field of a multipart
+// form or as the raw request body, plain or compressed like the coupon files.
+func (a *couponAdminService) UploadCoupons(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("UploadCoupons"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	version, ok := a.activeVersion(ctx, logger)
+	if !ok {
+		return
+	}
+
+	body, err := uploadBody(ctx, a.config.CouponConfig.MaxUploadSize)
+	if errors.Is(err, utils.ErrBodyTooLarge) {
+		a.uploadTooLarge(ctx)
+		return
+	}
+	if err != nil {
+		logger.Error("invalid upload", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"invalid upload, expected a multipart file field or a raw body"}`)
+		return
+	}
+	defer body.Close()
+
+	in, err := utils.NewDecompressedReader(body)
+	if err != nil {
+		logger.Error("failed to decompress upload", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"failed to decompress upload"}`)
+		return
+	}
+	defer in.Close()
+
+	var lines, added, invalid int64
+	batch := utils.NewBatch(a.config.CouponConfig.BatchSize, func(codes []string) error {
+		if err := a.addCodes(ctx, version, codes); err != nil {
+			return err
+		}
+		added += int64(len(codes))
+		return nil
+	})
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		code := utils.NormalizeCode(scanner.Text(), a.config.CouponConfig.Validation)
+		if code == "" {
+			continue
+		}
+		lines++
+
+		if !utils.ValidateCode(code, a.config.CouponConfig.Validation) {
+			invalid++
+			continue
+		}
+		if err = batch.Add(code); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err == nil {
+		err = batch.Flush()
+	}
+	if err == nil {
+		err = a.saveBloom(ctx, version)
+	}
+	if errors.Is(err, utils.ErrBodyTooLarge) {
+		logger.Error("coupon upload too large", zap.Int64("added", added))
+		a.uploadTooLarge(ctx)
+		return
+	}
+	if err != nil {
+		logger.Error("coupon upload failed", zap.Int64("added", added), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"coupon upload failed after %d codes"}`, added))
+		return
+	}
+
+	logger.Info("coupon upload completes", zap.Int64("lines", lines), zap.Int64("added", added), zap.Int64("invalid", invalid))
+	a.writeJSON(ctx, logger, fasthttp.StatusCreated, map[string]any{
+		"version": version,
+		"lines":   lines,
+		"added":   added,
+		"invalid": invalid,
+	})
+}
+
+// RevokeCoupon adds a coupon code to the revocation list. The request body is
+// optional and may carry the reason recorded for audit.
+func (a *couponAdminService) RevokeCoupon(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("RevokeCoupon"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	code, ok := a.pathCode(ctx)
+	if !ok {
+		return
+	}
+
+	var payload dto.RevokeCouponReq
+	if body := ctx.PostBody(); len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			logger.Error("invalid request payload", zap.Error(err))
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(`{"error":"invalid request payload"}`)
+			return
+		}
+	}
+
+	payload.Sanitize(constants.ADD)
+	if err := payload.Validate(); err != nil {
+		logger.Error("validation failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+
+	clientId, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	revocation, err := a.couponServicePorts.Revoke(ctx, code, payload.Reason, clientId)
+	if err != nil {
+		logger.Error("failed to revoke coupon", zap.String("coupon", code), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to revoke coupon"}`)
+		return
+	}
+
+	logger.Info("coupon revoked", zap.String("coupon", code), zap.String("reason", payload.Reason), zap.String("revokedBy", clientId))
+	a.writeJSON(ctx, logger, fasthttp.StatusOK, revocation)
+}
+
+// ReinstateCoupon removes a coupon code from the revocation list.
+func (a *couponAdminService) ReinstateCoupon(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("ReinstateCoupon"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	code, ok := a.pathCode(ctx)
+	if !ok {
+		return
+	}
+
+	revocation, err := a.couponServicePorts.Reinstate(ctx, code)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.SetBodyString(`{"error":"coupon is not revoked"}`)
+			return
+		}
+		logger.Error("failed to reinstate coupon", zap.String("coupon", code), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to reinstate coupon"}`)
+		return
+	}
+
+	clientId, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	logger.Info("coupon reinstated", zap.String("coupon", code), zap.String("reinstatedBy", clientId))
+	a.writeJSON(ctx, logger, fasthttp.StatusOK, map[string]any{
+		"reinstated": revocation,
+	})
+}
+
+// ListRevocations returns the revocation list, most recent first.
+func (a *couponAdminService) ListRevocations(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("ListRevocations"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	revocations, err := a.couponServicePorts.Revocations(ctx)
+	if err != nil {
+		logger.Error("failed to list revocations", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to list revocations"}`)
+		return
+	}
+
+	a.writeJSON(ctx, logger, fasthttp.StatusOK, map[string]any{
+		"count":       len(revocations),
+		"revocations": revocations,
+	})
+}
+
+// CouponStatus reports how a coupon code resolves against the active dataset.
+func (a *couponAdminService) CouponStatus(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := a.logger.With(zap.Namespace("CouponStatus"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	code, ok := utils.PathParamValue[string](ctx, "code")
+	code = utils.NormalizeCode(utils.Sanitize(code), a.config.CouponConfig.Validation)
+	if !ok || code == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"code is required"}`)
+		return
+	}
+
+	status, err := a.couponStatus(ctx, code)
+	if err != nil {
+		logger.Error("failed to read coupon status", zap.String("coupon", code), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to read coupon status"}`)
+		return
+	}
+
+	a.writeJSON(ctx, logger, fasthttp.StatusOK, status)
+}
+
+func (a *couponAdminService) couponStatus(ctx context.Context, code string) (*models.CouponStatus, error) {
+	status := &models.CouponStatus{
+		Code:        code,
+		FormatValid: utils.ValidateCode(code, a.config.CouponConfig.Validation),
+	}
+
+	dataset, err := a.couponServicePorts.ActiveDataset(ctx)
+	switch {
+	case err == nil:
+		status.Version = dataset.Version
+		bloomKey, exactKey := a.config.CouponConfig.DatasetKeys(dataset.Version)
+		if status.BloomHit, err = a.cacheRepository.BFExists(ctx, bloomKey, code); err != nil {
+			return nil, err
+		}
+		if status.ExactMember, err = a.cacheRepository.SIsMember(ctx, exactKey, code); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, utils.ErrNoData):
+		return nil, err
+	}
+
+	if status.AdminAdded, err = a.cacheRepository.SIsMember(ctx, a.config.CouponConfig.AdminCodesKey, code); err != nil {
+		return nil, err
+	}
+
+	status.Revocation, err = a.couponServicePorts.Revocation(ctx, code)
+	if err != nil && !errors.Is(err, utils.ErrNoData) {
+		return nil, err
+	}
+
+	if status.Redemptions, err = a.couponServicePorts.Redemptions(ctx, code); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// addCodes adds codes to version and records them so that they are carried
+// over to the versions built by later loads.
+func (a *couponAdminService) addCodes(ctx context.Context, version int64, codes []string) error {
+	members := make([]interface{}, len(codes))
+	for i, code := range codes {
+		members[i] = code
+	}
+	if err := a.cacheRepository.SAdd(ctx, a.config.CouponConfig.AdminCodesKey, members...); err != nil {
+		return err
+	}
+	return a.couponServicePorts.AddCodes(ctx, version, codes)
+}
+
+// saveBloom persists the Bloom filter of version after admin changes; only the
+// native backend has anything to do.
+func (a *couponAdminService) saveBloom(ctx context.Context, version int64) error {
+	bloomKey, _ := a.config.CouponConfig.DatasetKeys(version)
+	return a.cacheRepository.BFSave(ctx, bloomKey)
+}
+
+// activeVersion resolves the active dataset version, writing the error
+// response when there is none.
+func (a *couponAdminService) activeVersion(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts) (int64, bool) {
+	dataset, err := a.couponServicePorts.ActiveDataset(ctx)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusConflict)
+			ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, errNoActiveDataset.Error()))
+			return 0, false
+		}
+		logger.Error("failed to read active coupon dataset", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to read coupon dataset"}`)
+		return 0, false
+	}
+	return dataset.Version, true
+}
+
+func (a *couponAdminService) pathCode(ctx *fasthttp.RequestCtx) (string, bool) {
+	code, _ := utils.PathParamValue[string](ctx, "code")
+	code = utils.NormalizeCode(utils.Sanitize(code), a.config.CouponConfig.Validation)
+	if !utils.ValidateCode(code, a.config.CouponConfig.Validation) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"invalid coupon code"}`)
+		return "", false
+	}
+	return code, true
+}
+
+func (a *couponAdminService) writeJSON(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, statusCode int, body any) {
+	responseBody, err := json.Marshal(body)
+	if err != nil {
+		logger.Error("failed to marshal response", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	ctx.SetStatusCode(statusCode)
+	ctx.SetBody(responseBody)
+}
+
+// uploadBody returns the uploaded file, read as it streams in: the "file" field
+// of a multipart form, or the request body itself. Reading past limit bytes of
+// request body fails with utils.ErrBodyTooLarge.
+func uploadBody(ctx *fasthttp.RequestCtx, limit int64) (io.ReadCloser, error) {
+	stream := ctx.RequestBodyStream()
+	if !ctx.Request.IsBodyStream() {
+		stream = bytes.NewReader(ctx.PostBody())
+	}
+	body := utils.LimitReader(stream, limit)
+
+	boundary := ctx.Request.Header.MultipartFormBoundary()
+	if len(boundary) == 0 {
+		return io.NopCloser(body), nil
+	}
+
+	form := multipart.NewReader(body, string(boundary))
+	for {
+		part, err := form.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, fasthttp.ErrMissingFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func (a *couponAdminService) uploadTooLarge(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+	ctx.SetBodyString(fmt.Sprintf(`{"error":"upload exceeds %d bytes"}`, a.config.CouponConfig.MaxUploadSize))
+}
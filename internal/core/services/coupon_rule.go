@@ -0,0 +1,146 @@
+package services
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+)
+
+// pricedLine is an order line being priced. discount is what the coupons
+// applied so far took off the line.
+type pricedLine struct {
+	product  ingressModels.Product
+	quantity int
+	discount float64
+}
+
+func (l pricedLine) subtotal() float64 {
+	return l.product.Price * float64(l.quantity)
+}
+
+// remaining is the line subtotal less the discounts already applied to it.
+func (l pricedLine) remaining() float64 {
+	return l.subtotal() - l.discount
+}
+
+// applyCouponRule returns the discount rule grants on what is left of lines.
+// The second return value is false when the rule's conditions are not met by
+// the basket, whose total before any discount is basketTotal.
+func applyCouponRule(rule *ingressModels.CouponRule, lines []pricedLine, basketTotal float64) (float64, bool) {
+	if basketTotal < rule.MinBasketTotal {
+		return 0, false
+	}
+
+	var (
+		eligibleTotal float64
+		discount      float64
+	)
+	for _, line := range lines {
+		if !ruleCoversProduct(rule, &line.product) {
+			continue
+		}
+		eligibleTotal += line.remaining()
+
+		if rule.Type == constants.BuyXGetYDiscount {
+			group := rule.BuyQuantity + rule.GetQuantity
+			free := (line.quantity / group) * rule.GetQuantity
+			discount += float64(free) * line.product.Price
+		}
+	}
+
+	if eligibleTotal <= 0 {
+		return 0, false
+	}
+
+	switch rule.Type {
+	case constants.PercentageDiscount:
+		discount = eligibleTotal * rule.Value / 100
+	case constants.FixedDiscount:
+		discount = rule.Value
+	}
+
+	discount = math.Min(discount, eligibleTotal)
+	if rule.MaxDiscount > 0 {
+		discount = math.Min(discount, rule.MaxDiscount)
+	}
+
+	discount = utils.RoundFloat64(discount, 2)
+	return discount, discount > 0
+}
+
+// allocateDiscount spreads a discount granted by rule over the lines it covers,
+// in proportion to what is left of each.
+func allocateDiscount(rule *ingressModels.CouponRule, lines []pricedLine, discount float64) {
+	var eligibleTotal float64
+	for i := range lines {
+		if ruleCoversProduct(rule, &lines[i].product) {
+			eligibleTotal += lines[i].remaining()
+		}
+	}
+	if eligibleTotal <= 0 {
+		return
+	}
+
+	share := discount / eligibleTotal
+	for i := range lines {
+		if ruleCoversProduct(rule, &lines[i].product) {
+			lines[i].discount += lines[i].remaining() * share
+		}
+	}
+}
+
+func ruleCoversProduct(rule *ingressModels.CouponRule, product *ingressModels.Product) bool {
+	if len(rule.ProductIDs) == 0 && len(rule.Categories) == 0 {
+		return true
+	}
+
+	for _, id := range rule.ProductIDs {
+		if id == product.ID {
+			return true
+		}
+	}
+
+	for _, category := range rule.Categories {
+		if strings.EqualFold(category, product.Category) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleWindowError reports whether rule is outside its validity window at now.
+func ruleWindowError(rule *ingressModels.CouponRule, now time.Time) error {
+	if rule.ValidFrom != nil && now.Before(*rule.ValidFrom) {
+		return utils.ErrCouponNotActive
+	}
+	if rule.ValidUntil != nil && !now.Before(*rule.ValidUntil) {
+		return utils.ErrCouponExpired
+	}
+	return nil
+}
+
+// ruleCustomerError reports whether rule is restricted to customers other than
+// customerID, a member of segments. Anonymous orders never meet a restriction.
+func ruleCustomerError(rule *ingressModels.CouponRule, customerID string, segments []string) error {
+	if !rule.Restricted() {
+		return nil
+	}
+
+	if customerID != "" {
+		if slices.Contains(rule.Customers, customerID) {
+			return nil
+		}
+		for _, segment := range segments {
+			if slices.Contains(rule.Segments, segment) {
+				return nil
+			}
+		}
+	}
+	return utils.ErrCouponNotEligible
+}
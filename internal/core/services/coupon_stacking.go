@@ -0,0 +1,166 @@
+package services
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+)
+
+// couponCandidate is a coupon code of an order with the rule it resolved to.
+// discount is what the rule grants on the basket alone until the code is
+// stacked, and what it granted once stacked after.
+type couponCandidate struct {
+	code     string
+	rule     *ingressModels.CouponRule
+	discount float64
+}
+
+// couponCodeError ties a coupon error to the code it was raised for.
+type couponCodeError struct {
+	code string
+	err  error
+}
+
+func (e *couponCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *couponCodeError) Unwrap() error {
+	return e.err
+}
+
+// stackCoupons settles which candidates are applied under
+// couponConfig.stacking and applies them to lines. It returns the applied
+// candidates in the order they were applied, with the discount each granted,
+// and the codes left out. With the reject resolution no code is ever left out:
+// the order is rejected instead.
+func (o *orderService) stackCoupons(candidates []couponCandidate, lines []pricedLine, basketTotal float64) ([]couponCandidate, []string, error) {
+	cfg := o.config.CouponConfig.StackingRules()
+
+	var exclusive, stackable []couponCandidate
+	for _, candidate := range candidates {
+		if candidate.rule.Exclusive {
+			exclusive = append(exclusive, candidate)
+		} else {
+			stackable = append(stackable, candidate)
+		}
+	}
+
+	if cfg.Resolution == constants.StackReject {
+		if len(candidates) > cfg.MaxCodes {
+			return nil, nil, &couponCodeError{code: candidates[cfg.MaxCodes].code, err: utils.ErrCouponNotStackable}
+		}
+		if len(exclusive) > 0 && len(candidates) > 1 {
+			return nil, nil, &couponCodeError{code: exclusive[0].code, err: utils.ErrCouponNotStackable}
+		}
+
+		applied, unapplied := applyStack(cfg.Order, candidates, lines, basketTotal)
+		if len(unapplied) > 0 {
+			return nil, nil, &couponCodeError{code: unapplied[0].code, err: utils.ErrCouponNotApplicable}
+		}
+		return applied, nil, nil
+	}
+
+	// best-discount: every exclusive code alone, and every set of at most
+	// maxCodes stackable codes, compete for the largest total discount.
+	// Orders carry at most constants.MaxOrderCouponCodes codes, which bounds
+	// the sets to try. Earlier options win ties.
+	options := make([][]couponCandidate, 0, len(exclusive)+1<<len(stackable))
+	for _, candidate := range exclusive {
+		options = append(options, []couponCandidate{candidate})
+	}
+	options = append(options, stackableSubsets(stackable, cfg.MaxCodes)...)
+
+	var (
+		best      []couponCandidate
+		bestLines []pricedLine
+		bestTotal float64
+	)
+	for _, option := range options {
+		optionLines := append([]pricedLine(nil), lines...)
+		applied, _ := applyStack(cfg.Order, option, optionLines, basketTotal)
+
+		var total float64
+		for _, candidate := range applied {
+			total += candidate.discount
+		}
+		if best == nil || total > bestTotal {
+			best, bestLines, bestTotal = applied, optionLines, total
+		}
+	}
+	copy(lines, bestLines)
+
+	kept := make(map[string]bool, len(best))
+	for _, candidate := range best {
+		kept[candidate.code] = true
+	}
+	var dropped []string
+	for _, candidate := range candidates {
+		if !kept[candidate.code] {
+			dropped = append(dropped, candidate.code)
+		}
+	}
+	return best, dropped, nil
+}
+
+// stackableSubsets returns every non-empty subset of candidates with at most
+// maxCodes members, each in the order of candidates. Subsets come in the order
+// of their bitmask, so a subset always comes before its supersets.
+func stackableSubsets(candidates []couponCandidate, maxCodes int) [][]couponCandidate {
+	var subsets [][]couponCandidate
+	for mask := 1; mask < 1<<len(candidates); mask++ {
+		if bits.OnesCount(uint(mask)) > maxCodes {
+			continue
+		}
+		subset := make([]couponCandidate, 0, maxCodes)
+		for i, candidate := range candidates {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, candidate)
+			}
+		}
+		subsets = append(subsets, subset)
+	}
+	return subsets
+}
+
+// applyStack applies candidates to lines one after the other, in order. It
+// returns the candidates that granted a discount, and those left with nothing
+// to discount by the ones before them.
+func applyStack(order constants.StackingOrder, candidates []couponCandidate, lines []pricedLine, basketTotal float64) ([]couponCandidate, []couponCandidate) {
+	ordered := append([]couponCandidate(nil), candidates...)
+	if order == constants.StackPercentageFirst {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return stackRank(ordered[i].rule.Type) < stackRank(ordered[j].rule.Type)
+		})
+	}
+
+	applied := make([]couponCandidate, 0, len(ordered))
+	var unapplied []couponCandidate
+	for _, candidate := range ordered {
+		discount, ok := applyCouponRule(candidate.rule, lines, basketTotal)
+		if !ok {
+			unapplied = append(unapplied, candidate)
+			continue
+		}
+		allocateDiscount(candidate.rule, lines, discount)
+
+		candidate.discount = discount
+		applied = append(applied, candidate)
+	}
+	return applied, unapplied
+}
+
+// stackRank orders discount types for percentage-first stacking.
+func stackRank(discountType constants.DiscountType) int {
+	switch discountType {
+	case constants.BuyXGetYDiscount:
+		return 0
+	case constants.PercentageDiscount:
+		return 1
+	default:
+		return 2
+	}
+}
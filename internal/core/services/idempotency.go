@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotent runs handle once per Idempotency-Key of the caller's API key. The
+// first request claims the key with the hash of its body; once handled, its
+// response is kept for idempotency.ttl and replayed to the retries with the
+// same body. A retry with another body is refused with 422, and one arriving
+// while the first is still being handled with 409. Server errors release the
+// key, so the request can be retried. Keys are scoped to the API key, so the
+// header is refused when API keys are disabled.
+func (o *orderService) idempotent(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, key string, handle func(*fasthttp.RequestCtx, ports.LoggerPorts)) {
+	if !validIdempotencyKey(key) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s must be 1 to %d printable ASCII characters"}`, constants.IDEMPOTENCY_KEY, maxIdempotencyKeyLength))
+		return
+	}
+
+	cfg := o.config.Idempotency
+	clientID, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	if clientID == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s requires an API key"}`, constants.IDEMPOTENCY_KEY))
+		return
+	}
+	cacheKey := fmt.Sprintf("%s:%s:%s", cfg.KeyPrefix, clientID, key)
+
+	sum := sha256.Sum256(ctx.PostBody())
+	record := models.IdempotencyRecord{RequestHash: hex.EncodeToString(sum[:])}
+	pending, _ := json.Marshal(record)
+
+	claimCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	claimed, err := o.cacheRepository.AcquireLock(claimCtx, cacheKey, string(pending), cfg.LockTTL)
+	if err != nil {
+		logger.Error("failed to claim idempotency key", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to check idempotency key"}`)
+		return
+	}
+	if !claimed {
+		o.replay(ctx, logger, cacheKey, record.RequestHash)
+		return
+	}
+
+	handle(ctx, logger)
+
+	storeCtx, cancelStore := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelStore()
+
+	// Both only apply while the key still holds this request's claim, which
+	// may have expired and been taken by a retry in the meantime.
+	record.Status = ctx.Response.StatusCode()
+	if record.Status >= fasthttp.StatusInternalServerError {
+		if err := o.cacheRepository.ReleaseLock(storeCtx, cacheKey, string(pending)); err != nil {
+			logger.Error("failed to release idempotency key", zap.Error(err))
+		}
+		return
+	}
+
+	record.Body = ctx.Response.Body()
+	raw, _ := json.Marshal(record)
+	stored, err := o.cacheRepository.CompareAndSet(storeCtx, cacheKey, string(pending), raw, cfg.TTL)
+	if err != nil {
+		logger.Error("failed to store idempotent response", zap.Error(err))
+		return
+	}
+	if !stored {
+		logger.Warn("idempotency key claim expired before the response was stored", zap.Duration("lockTTL", cfg.LockTTL))
+	}
+}
+
+// replay answers a request whose Idempotency-Key was already claimed.
+func (o *orderService) replay(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, cacheKey, requestHash string) {
+	cacheCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	raw, err := o.cacheRepository.Get(cacheCtx, cacheKey)
+	if err != nil && !errors.Is(err, utils.ErrNoData) {
+		logger.Error("failed to read idempotency key", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to check idempotency key"}`)
+		return
+	}
+
+	var record models.IdempotencyRecord
+	if err == nil {
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			logger.Error("invalid idempotency record", zap.Error(err))
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(`{"error":"failed to check idempotency key"}`)
+			return
+		}
+	}
+
+	switch {
+	case err == nil && record.RequestHash != requestHash:
+		logger.Warn("idempotency key reused with another request")
+		ctx.SetStatusCode(fasthttp.StatusUnprocessableEntity)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s was already used with another request"}`, constants.IDEMPOTENCY_KEY))
+	case err != nil || record.Status == 0:
+		// Released or expired since it was claimed, or still being handled.
+		logger.Info("idempotency key in use")
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, "1")
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"a request with this %s is being processed, retry later"}`, constants.IDEMPOTENCY_KEY))
+	default:
+		logger.Info("replaying idempotent response", zap.Int("status", record.Status))
+		ctx.Response.Header.Set(constants.IDEMPOTENT_REPLAYED.String(), "true")
+		ctx.SetStatusCode(record.Status)
+		ctx.SetBody(record.Body)
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
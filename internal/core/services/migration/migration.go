@@ -2,7 +2,9 @@ package migration
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"slices"
 	"time"
 
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
@@ -28,15 +30,152 @@ func NewMigrationService(config *models.Config, logger ports.LoggerPorts, client
 }
 
 func (m *migrationService) Migrate() {
-	m.client.AutoMigrate(
+	if err := m.migrateRedemptionsSchema(); err != nil {
+		m.logger.Error("coupon redemption migration failed", zap.Error(err))
+		return
+	}
+
+	if err := m.client.AutoMigrate(
 		&ingressModels.Product{},
 		&ingressModels.Order{},
 		&ingressModels.Item{},
-	)
+		&ingressModels.OrderStatusChange{},
+		&ingressModels.CouponRule{},
+		&ingressModels.CouponRedemption{},
+		&ingressModels.CustomerSegment{},
+	); err != nil {
+		m.logger.Error("auto migration failed", zap.Error(err))
+		return
+	}
+
+	if err := m.migrateOrderDiscounts(); err != nil {
+		m.logger.Error("order discount migration failed", zap.Error(err))
+		return
+	}
+	if err := m.migrateItemSnapshots(); err != nil {
+		m.logger.Error("order item migration failed", zap.Error(err))
+	}
+}
+
+// migrateRedemptionsSchema prepares coupon_redemptions, from when an order
+// redeemed a single code, for AutoMigrate: the unique index on order_id is
+// dropped, and the type column, which AutoMigrate cannot add as NOT NULL to
+// rows already there, is added and filled in from the coupon rules.
+func (m *migrationService) migrateRedemptionsSchema() error {
+	if !m.client.Migrator().HasTable(&ingressModels.CouponRedemption{}) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return m.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		migrator := tx.Migrator()
+		if migrator.HasIndex(&ingressModels.CouponRedemption{}, "idx_coupon_redemptions_order_id") {
+			if err := migrator.DropIndex(&ingressModels.CouponRedemption{}, "idx_coupon_redemptions_order_id"); err != nil {
+				return err
+			}
+		}
+
+		if migrator.HasColumn(&ingressModels.CouponRedemption{}, "type") {
+			return nil
+		}
+		if err := tx.Exec(`ALTER TABLE coupon_redemptions ADD COLUMN type text NOT NULL DEFAULT ''`).Error; err != nil {
+			return err
+		}
+		if migrator.HasTable(&ingressModels.CouponRule{}) {
+			if err := tx.Exec(`UPDATE coupon_redemptions r SET type = c.type FROM coupon_rules c WHERE c.name = r.coupon_rule`).Error; err != nil {
+				return err
+			}
+		}
+		m.logger.Info("coupon redemptions migrated to several codes per order")
+		return tx.Exec(`ALTER TABLE coupon_redemptions ALTER COLUMN type DROP DEFAULT`).Error
+	})
+}
+
+// migrateOrderDiscounts moves the discount of orders placed with a single
+// coupon code, kept in the discounts, coupon_code and coupon_rule columns of
+// orders, to the discount column and a coupon redemption, unless the order
+// has one already, then drops the old columns.
+func (m *migrationService) migrateOrderDiscounts() error {
+	var columns []string
+	for _, column := range []string{"discounts", "coupon_code", "coupon_rule"} {
+		if m.client.Migrator().HasColumn(&ingressModels.Order{}, column) {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	legacy := func(column string) bool { return slices.Contains(columns, column) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	return m.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		discount, rule := "0", "''"
+		if legacy("discounts") {
+			discount = "COALESCE(o.discounts, 0)"
+			if err := tx.Exec(`UPDATE orders SET discount = COALESCE(discounts, 0) WHERE discount IS NULL`).Error; err != nil {
+				return err
+			}
+		}
+		if legacy("coupon_rule") {
+			rule = "COALESCE(o.coupon_rule, '')"
+		}
+
+		if legacy("coupon_code") {
+			backfill := fmt.Sprintf(`INSERT INTO coupon_redemptions (order_id, customer_id, coupon_code, coupon_rule, type, discount, created_at)
+				SELECT o.id, COALESCE(o.customer_id, ''), o.coupon_code, %[1]s, COALESCE(c.type, ''), %[2]s, o.created_at
+				FROM orders o LEFT JOIN coupon_rules c ON c.name = %[1]s
+				WHERE COALESCE(o.coupon_code, '') <> ''
+				AND NOT EXISTS (SELECT 1 FROM coupon_redemptions r WHERE r.order_id = o.id)`, rule, discount)
+			result := tx.Exec(backfill)
+			if result.Error != nil {
+				return result.Error
+			}
+			m.logger.Info("coupon redemptions backfilled from orders", zap.Int64("orders", result.RowsAffected))
+		}
+
+		for _, column := range columns {
+			if err := tx.Migrator().DropColumn(&ingressModels.Order{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// migrateItemSnapshots fills in the product name, unit price and subtotal of
+// the order items stored before they were snapshotted, from the products as
+// they are now, and spreads the discount of their order over them by subtotal.
+// Items whose product is gone are left as they are.
+func (m *migrationService) migrateItemSnapshots() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	result := m.client.WithContext(ctx).Exec(`WITH legacy AS (
+			SELECT i.id, i.order_id, p.name, p.price, p.price * i.quantity AS subtotal
+			FROM items i JOIN products p ON p.id = i.product_id
+			WHERE i.product_name = ''
+		), totals AS (
+			SELECT order_id, SUM(subtotal) AS subtotal FROM legacy GROUP BY order_id
+		)
+		UPDATE items SET product_name = l.name, unit_price = l.price, subtotal = l.subtotal,
+			discount = CASE WHEN t.subtotal > 0 THEN COALESCE(o.discount, 0) * l.subtotal / t.subtotal ELSE 0 END
+		FROM legacy l JOIN totals t ON t.order_id = l.order_id JOIN orders o ON o.id = l.order_id
+		WHERE items.id = l.id`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		m.logger.Info("order items backfilled from products", zap.Int64("items", result.RowsAffected))
+	}
+	return nil
 }
 
 func (m *migrationService) Seed() {
 	m.seedProducts()
+	m.seedCouponRules()
+	m.seedCustomerSegments()
 }
 
 func (m *migrationService) seedProducts() {
@@ -96,6 +235,63 @@ func (m *migrationService) seedProducts() {
 	m.logger.Info("product seeding completed")
 }
 
+func (m *migrationService) seedCouponRules() {
+	var count int64
+	if err := m.client.Model(&ingressModels.CouponRule{}).Count(&count).Error; err != nil {
+		m.logger.Error("count check failed", zap.Error(err))
+		return
+	}
+	if count > 0 {
+		m.logger.Info("coupon rules already seeded")
+		return
+	}
+
+	rules := m.config.CouponConfig.Rules.Definitions
+	if len(rules) == 0 {
+		m.logger.Info("no coupon rules to seed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.client.WithContext(ctx).Create(&rules).Error; err != nil {
+		m.logger.Error("coupon rule seeding failed", zap.Error(err))
+		return
+	}
+	m.logger.Info("coupon rule seeding completed")
+}
+
+func (m *migrationService) seedCustomerSegments() {
+	var count int64
+	if err := m.client.Model(&ingressModels.CustomerSegment{}).Count(&count).Error; err != nil {
+		m.logger.Error("count check failed", zap.Error(err))
+		return
+	}
+	if count > 0 {
+		m.logger.Info("customer segments already seeded")
+		return
+	}
+
+	var segments []ingressModels.CustomerSegment
+	for segment, customers := range m.config.CouponConfig.Rules.Segments {
+		for _, customerID := range customers {
+			segments = append(segments, ingressModels.CustomerSegment{CustomerID: customerID, Segment: segment})
+		}
+	}
+	if len(segments) == 0 {
+		m.logger.Info("no customer segments to seed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.client.WithContext(ctx).Create(&segments).Error; err != nil {
+		m.logger.Error("customer segment seeding failed", zap.Error(err))
+		return
+	}
+	m.logger.Info("customer segment seeding completed")
+}
+
 func randomImage() string {
 	images := []string{
 		"https://picsum.photos/200/200?random=1",
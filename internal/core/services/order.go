@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
@@ -21,66 +22,279 @@ import (
 )
 
 type orderService struct {
-	config            *models.Config
-	logger            ports.LoggerPorts
-	orderRepository   egressPorts.OrderRepository
-	cacheRepository   egressPorts.CacheRepository
-	productRepository egressPorts.ProductRepository
+	config                *models.Config
+	logger                ports.LoggerPorts
+	orderRepository       egressPorts.OrderRepository
+	productRepository     egressPorts.ProductRepository
+	cacheRepository       egressPorts.CacheRepository
+	couponServicePorts    ingressPorts.CouponServicePorts
+	readinessServicePorts ingressPorts.ReadinessServicePorts
 }
 
-func NewOrderService(config *models.Config, logger ports.LoggerPorts, orderRepository egressPorts.OrderRepository, cacheRepository egressPorts.CacheRepository, productRepository egressPorts.ProductRepository) ingressPorts.OrderServicePorts {
+func NewOrderService(config *models.Config, logger ports.LoggerPorts, orderRepository egressPorts.OrderRepository, productRepository egressPorts.ProductRepository, cacheRepository egressPorts.CacheRepository, couponServicePorts ingressPorts.CouponServicePorts, readinessServicePorts ingressPorts.ReadinessServicePorts) ingressPorts.OrderServicePorts {
 	return &orderService{
-		config:            config,
-		logger:            logger,
-		orderRepository:   orderRepository,
-		cacheRepository:   cacheRepository,
-		productRepository: productRepository,
+		config:                config,
+		logger:                logger,
+		orderRepository:       orderRepository,
+		productRepository:     productRepository,
+		cacheRepository:       cacheRepository,
+		couponServicePorts:    couponServicePorts,
+		readinessServicePorts: readinessServicePorts,
 	}
 }
 
+// CreateOrder places an order. A request carrying an Idempotency-Key is
+// handled once per key; retries get the original response back.
 func (o *orderService) CreateOrder(ctx *fasthttp.RequestCtx) {
 	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
 	logger := o.logger.With(zap.Namespace("CreateOrder"), zap.String(constants.CtxRequestID.String(), requestId))
 
+	if key, ok := utils.HeaderValue[string](ctx, constants.IDEMPOTENCY_KEY.String()); ok && o.config.Idempotency != nil {
+		o.idempotent(ctx, logger, key, o.createOrder)
+		return
+	}
+	o.createOrder(ctx, logger)
+}
+
+func (o *orderService) createOrder(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts) {
+	payload, customerID, ok := o.parseOrderRequest(ctx, logger)
+	if !ok {
+		return
+	}
+
+	codes, ok := o.readyCouponCodes(ctx, logger, payload)
+	if !ok {
+		return
+	}
+
+	coupons, rejected, err := o.verifyCoupons(ctx, codes)
+	if err != nil {
+		logger.Error("coupon validation failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to validate coupon"}`)
+		return
+	}
+	if len(rejected) > 0 {
+		logger.Warn("coupon rejected", zap.Error(rejected[0]))
+		writeCouponError(ctx, rejected[0])
+		return
+	}
+
+	products, ok := o.orderProducts(ctx, logger, payload)
+	if !ok {
+		return
+	}
+
+	pricing, err := o.buildOrderFromRequest(coupons, products, payload, customerID)
+	if err != nil {
+		logger.Error("failed to build order payload", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+	if len(pricing.rejected) > 0 {
+		logger.Warn("coupon rejected", zap.Error(pricing.rejected[0]))
+		writeCouponError(ctx, pricing.rejected[0])
+		return
+	}
+
+	if len(pricing.dropped) > 0 {
+		logger.Info("coupons left out by stacking rules", zap.Strings("coupons", pricing.dropped))
+	}
+
+	if err := o.redeemCoupons(ctx, logger, pricing.applied, customerID); err != nil {
+		if isCouponError(err) {
+			logger.Warn("coupon redemption rejected", zap.Error(err))
+			writeCouponError(ctx, err)
+			return
+		}
+		logger.Error("coupon redemption failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to redeem coupon"}`)
+		return
+	}
+
+	orderPayload := pricing.order
+	orderPayload.ClientID, _ = utils.CtxValue[string](ctx, constants.CtxClientID)
+	orderPayload.Status = constants.OrderPlaced
+	orderPayload.StatusHistory = []ingressModels.OrderStatusChange{{
+		ToStatus:  constants.OrderPlaced,
+		ChangedBy: orderPayload.ClientID,
+	}}
+	dbCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	if err := o.orderRepository.CreateOrder(dbCtx, orderPayload); err != nil {
+		o.releaseCoupons(logger, pricing.applied, customerID)
+
+		switch {
+		case errors.Is(err, utils.ErrDuplicateKey):
+			logger.Warn("order already exists")
+			ctx.SetStatusCode(fasthttp.StatusConflict)
+			ctx.SetBodyString(`{"error":"order already exists"}`)
+		default:
+			logger.Error("failed to create order", zap.Error(err))
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(`{"error":"internal server error"}`)
+		}
+		return
+	}
+
+	response := map[string]any{
+		"message":   "order created successfully",
+		"orderId":   orderPayload.Id,
+		"total":     orderPayload.Total,
+		"discount":  orderPayload.Discount,
+		"items":     orderPayload.Items,
+		"discounts": orderPayload.Discounts,
+	}
+	if len(pricing.dropped) > 0 {
+		response["droppedCouponCodes"] = pricing.dropped
+	}
+	responseBody, _ := json.Marshal(response)
+	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetBody(responseBody)
+}
+
+// QuoteOrder prices an order request the way CreateOrder would, without
+// redeeming its coupons or storing it. Coupon codes CreateOrder would refuse do
+// not fail the quote: they are listed with the reason and left out of the
+// totals. Coupons that are not ready are handled as CreateOrder handles them.
+func (o *orderService) QuoteOrder(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("QuoteOrder"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	payload, customerID, ok := o.parseOrderRequest(ctx, logger)
+	if !ok {
+		return
+	}
+
+	codes, ok := o.readyCouponCodes(ctx, logger, payload)
+	if !ok {
+		return
+	}
+
+	coupons, rejected, err := o.verifyCoupons(ctx, codes)
+	if err != nil {
+		logger.Error("coupon validation failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"failed to validate coupon"}`)
+		return
+	}
+
+	products, ok := o.orderProducts(ctx, logger, payload)
+	if !ok {
+		return
+	}
+
+	// Coupons whose redemption limits are reached are only known once priced,
+	// as the limits are those of the rule a code resolves to; the order is
+	// priced again without them.
+	var pricing *orderPricing
+	for {
+		if pricing, err = o.buildOrderFromRequest(coupons, products, payload, customerID); err != nil {
+			logger.Error("failed to build order payload", zap.Error(err))
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+			return
+		}
+
+		exhausted, err := o.checkRedemptions(ctx, pricing.applied, customerID)
+		if err != nil {
+			logger.Error("coupon redemption check failed", zap.Error(err))
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(`{"error":"failed to check coupon redemptions"}`)
+			return
+		}
+		if len(exhausted) == 0 {
+			break
+		}
+
+		rejected = append(rejected, exhausted...)
+		coupons = slices.DeleteFunc(coupons, func(coupon codeRules) bool {
+			return slices.ContainsFunc(exhausted, func(e *couponCodeError) bool { return e.code == coupon.code })
+		})
+	}
+
+	rejected = append(rejected, pricing.rejected...)
+	for _, code := range pricing.dropped {
+		rejected = append(rejected, &couponCodeError{code: code, err: utils.ErrCouponNotStackable})
+	}
+
+	responseBody, _ := json.Marshal(pricing.quote(rejected))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+// parseOrderRequest reads, normalises and validates the order payload of ctx,
+// merges the lines of a same product and resolves the customer it is for. It
+// answers the request itself and returns false when the payload is refused.
+func (o *orderService) parseOrderRequest(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts) (*dto.OrderReq, string, bool) {
 	var payload dto.OrderReq
 	if err := json.Unmarshal(ctx.PostBody(), &payload); err != nil {
 		logger.Error("invalid request payload", zap.Error(err))
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
 		ctx.SetBodyString(`{"error":"invalid request payload"}`)
-		return
+		return nil, "", false
 	}
 
 	payload.Sanitize(constants.ADD)
+	payload.Normalize(o.config.CouponConfig.Validation)
 	if err := payload.Validate(o.config.CouponConfig.Validation); err != nil {
 		logger.Error("validation failed", zap.Error(err))
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
 		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
-		return
+		return nil, "", false
 	}
 
-	var discountPercentage float32
-	if payload.CouponCode != "" {
-		ctxCache, cancel := context.WithTimeout(ctx, 2*time.Second)
-		defer cancel()
+	if payload.MergeItems() {
+		logger.Info("merged order lines of the same product", zap.Int("lines", len(payload.Items)))
+	}
 
-		exists, err := o.cacheRepository.BFExists(ctxCache, o.config.CouponConfig.BloomKey, payload.CouponCode)
-		if err != nil {
-			logger.Error("coupon validation failed", zap.Error(err))
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-			ctx.SetBodyString(`{"error":"failed to validate coupon"}`)
-			return
-		}
-		if !exists {
-			logger.Warn("coupon does not exist", zap.String("coupon", payload.CouponCode))
-			ctx.SetStatusCode(fasthttp.StatusBadRequest)
-			ctx.SetBodyString(`{"error":"coupon code does not exist"}`)
-			return
-		}
+	customerID, ok := o.customerID(ctx, &payload)
+	if !ok {
+		logger.Warn("customer id does not match the api key", zap.String("customerId", payload.CustomerID))
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBodyString(`{"error":"customerId does not match the API key"}`)
+		return nil, "", false
+	}
+	return &payload, customerID, true
+}
 
-		// flat 20% discount if coupon exists
-		discountPercentage = 20
+// readyCouponCodes returns the coupon codes of payload to verify, following
+// couponConfig.notReadyPolicy while coupons are not ready: ignore-coupon
+// clears them from payload, best-effort keeps them and reject answers the
+// request with 503 and returns false.
+func (o *orderService) readyCouponCodes(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, payload *dto.OrderReq) ([]string, bool) {
+	codes := payload.Codes()
+	if len(codes) == 0 || o.readinessServicePorts.CouponsReady() {
+		return codes, true
 	}
 
+	switch o.config.CouponConfig.NotReadyPolicy {
+	case constants.NotReadyIgnoreCoupon:
+		logger.Warn("coupons not ready, ignoring coupons", zap.Strings("coupons", codes))
+		payload.ClearCoupons()
+		return nil, true
+	case constants.NotReadyBestEffort:
+		logger.Warn("coupons not ready, verifying against the previously active dataset", zap.Strings("coupons", codes))
+		return codes, true
+	default:
+		logger.Warn("coupons not ready, rejecting order", zap.Strings("coupons", codes))
+		responseBody, _ := json.Marshal(map[string]any{
+			"error": utils.ErrCouponsNotReady.Error(),
+			"code":  constants.ERR_COUPONS_NOT_READY,
+		})
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, "30")
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetBody(responseBody)
+		return nil, false
+	}
+}
+
+// orderProducts fetches the products of the order items, which name each
+// product once. It answers the request itself and returns false when they
+// cannot all be found, listing the missing product IDs.
+func (o *orderService) orderProducts(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, payload *dto.OrderReq) ([]ingressModels.Product, bool) {
 	productIds := make([]int64, 0, len(payload.Items))
 	for _, item := range payload.Items {
 		productIds = append(productIds, item.ProductID)
@@ -93,49 +307,230 @@ func (o *orderService) CreateOrder(ctx *fasthttp.RequestCtx) {
 		logger.Error("failed to fetch products", zap.Error(err))
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.SetBodyString(`{"error":"failed to fetch products"}`)
-		return
+		return nil, false
 	}
 
-	if len(products) != len(productIds) {
-		logger.Error("some products not found in DB")
+	if missing := missingProducts(productIds, products); len(missing) > 0 {
+		logger.Error("some products not found in DB", zap.Int64s("productIds", missing))
+		responseBody, _ := json.Marshal(map[string]any{
+			"error":             "some products not found",
+			"missingProductIds": missing,
+		})
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		ctx.SetBodyString(`{"error":"some products not found"}`)
-		return
+		ctx.SetBody(responseBody)
+		return nil, false
 	}
+	return products, true
+}
 
-	orderPayload, err := o.buildOrderFromRequest(discountPercentage, products, &payload)
-	if err != nil {
-		logger.Error("failed to build order payload", zap.Error(err))
+// customerID returns the customer the order is placed for: the one the
+// payload names when the API key may act for other customers, otherwise the
+// API key itself. It reports false when the payload names another customer
+// the API key may not act for.
+func (o *orderService) customerID(ctx *fasthttp.RequestCtx, orderReq *dto.OrderReq) (string, bool) {
+	clientID, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	if orderReq.CustomerID == "" || orderReq.CustomerID == clientID {
+		return clientID, true
+	}
+	return orderReq.CustomerID, o.actsForCustomers(ctx)
+}
+
+// actsForCustomers reports whether the API key of ctx may act for any
+// customer.
+func (o *orderService) actsForCustomers(ctx *fasthttp.RequestCtx) bool {
+	trusted, _ := utils.CtxValue[bool](ctx, constants.CtxTrusted)
+	return trusted && o.config.ApiKey.Customers() == constants.CustomerFromPayload
+}
+
+const (
+	defaultRedemptionLimit = 50
+	maxRedemptionLimit     = 500
+)
+
+// ListRedemptions returns the coupon redemptions of the customer in the path,
+// most recent first, optionally for a single couponCode. An API key can only
+// read its own history, unless it may act for any customer.
+func (o *orderService) ListRedemptions(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("ListRedemptions"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	customerID, _ := utils.PathParamValue[string](ctx, "customerId")
+	if !utils.ValidateCustomerID(customerID) {
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
-		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		ctx.SetBodyString(`{"error":"invalid customer id"}`)
 		return
 	}
 
-	dbCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
-	defer cancel()
-	if err := o.orderRepository.CreateOrder(dbCtx, orderPayload); err != nil {
-		switch {
-		case errors.Is(err, utils.ErrDuplicateKey):
-			logger.Warn("order already exists")
-			ctx.SetStatusCode(fasthttp.StatusConflict)
-			ctx.SetBodyString(`{"error":"order already exists"}`)
-		default:
-			logger.Error("failed to create order", zap.Error(err))
-			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-			ctx.SetBodyString(`{"error":"internal server error"}`)
+	if clientID, _ := utils.CtxValue[string](ctx, constants.CtxClientID); clientID != customerID && !o.actsForCustomers(ctx) {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBodyString(`{"error":"customerId does not match the API key"}`)
+		return
+	}
+
+	var couponCode string
+	if raw := ctx.QueryArgs().Peek("couponCode"); len(raw) > 0 {
+		couponCode = utils.NormalizeCode(utils.Sanitize(string(raw)), o.config.CouponConfig.Validation)
+	}
+
+	limit := defaultRedemptionLimit
+	if ctx.QueryArgs().Has("limit") {
+		n, err := ctx.QueryArgs().GetUint("limit")
+		if err != nil || n < 1 || n > maxRedemptionLimit {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(fmt.Sprintf(`{"error":"limit must be between 1 and %d"}`, maxRedemptionLimit))
+			return
 		}
+		limit = n
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	redemptions, err := o.orderRepository.ListRedemptions(dbCtx, customerID, couponCode, limit)
+	if err != nil {
+		logger.Error("failed to list redemptions", zap.String("customerId", customerID), zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
 		return
 	}
 
 	responseBody, _ := json.Marshal(map[string]any{
-		"message": "order created successfully",
-		"orderId": orderPayload.Id,
+		"customerId":  customerID,
+		"count":       len(redemptions),
+		"redemptions": redemptions,
 	})
-	ctx.SetStatusCode(fasthttp.StatusCreated)
+	ctx.SetStatusCode(fasthttp.StatusOK)
 	ctx.SetBody(responseBody)
 }
 
-func (o *orderService) buildOrderFromRequest(discountPercentage float32, products []ingressModels.Product, orderReq *dto.OrderReq) (*ingressModels.Order, error) {
+// missingProducts returns the IDs of productIds with no product in products,
+// in the order they were requested.
+func missingProducts(productIds []int64, products []ingressModels.Product) []int64 {
+	found := make(map[int64]bool, len(products))
+	for _, product := range products {
+		found[product.ID] = true
+	}
+
+	var missing []int64
+	for _, id := range productIds {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// codeRules is a verified coupon code of an order with its candidate rules.
+type codeRules struct {
+	code  string
+	rules []ingressModels.CouponRule
+}
+
+// verifyCoupons checks that every code is a known, unrevoked coupon and
+// returns the candidate rules of each, along with the codes refused, each
+// carrying the reason.
+func (o *orderService) verifyCoupons(ctx context.Context, codes []string) ([]codeRules, []*couponCodeError, error) {
+	if len(codes) == 0 {
+		return nil, nil, nil
+	}
+
+	ctxCache, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var rejected []*couponCodeError
+	coupons := make([]codeRules, 0, len(codes))
+	for _, code := range codes {
+		exists, err := o.couponServicePorts.Verify(ctxCache, code)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exists {
+			rejected = append(rejected, &couponCodeError{code: code, err: utils.ErrCouponNotFound})
+			continue
+		}
+
+		revoked, err := o.couponServicePorts.IsRevoked(ctxCache, code)
+		if err != nil {
+			return nil, nil, fmt.Errorf("coupon revocation check failed: %w", err)
+		}
+		if revoked {
+			rejected = append(rejected, &couponCodeError{code: code, err: utils.ErrCouponRevoked})
+			continue
+		}
+
+		coupons = append(coupons, codeRules{code: code, rules: o.couponServicePorts.Rules(code)})
+	}
+	return coupons, rejected, nil
+}
+
+// checkRedemptions returns the applied coupons Redeem would currently refuse
+// because a redemption limit is reached.
+func (o *orderService) checkRedemptions(ctx context.Context, applied []couponCandidate, customerID string) ([]*couponCodeError, error) {
+	checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var rejected []*couponCodeError
+	for _, coupon := range applied {
+		err := o.couponServicePorts.CheckRedemption(checkCtx, coupon.code, customerID, coupon.rule)
+		if err == nil {
+			continue
+		}
+		if !isCouponError(err) {
+			return nil, err
+		}
+		rejected = append(rejected, &couponCodeError{code: coupon.code, err: err})
+	}
+	return rejected, nil
+}
+
+// redeemCoupons records the redemption of every applied coupon. When one is
+// rejected, those redeemed before it are released.
+func (o *orderService) redeemCoupons(ctx context.Context, logger ports.LoggerPorts, applied []couponCandidate, customerID string) error {
+	redeemCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	for i, coupon := range applied {
+		if err := o.couponServicePorts.Redeem(redeemCtx, coupon.code, customerID, coupon.rule); err != nil {
+			o.releaseCoupons(logger, applied[:i], customerID)
+			if isCouponError(err) {
+				return &couponCodeError{code: coupon.code, err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseCoupons reverts the redemptions recorded by redeemCoupons.
+func (o *orderService) releaseCoupons(logger ports.LoggerPorts, applied []couponCandidate, customerID string) {
+	releaseCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for _, coupon := range applied {
+		if err := o.couponServicePorts.Release(releaseCtx, coupon.code, customerID); err != nil {
+			logger.Error("failed to release coupon redemption", zap.String("coupon", coupon.code), zap.Error(err))
+		}
+	}
+}
+
+// orderPricing is a priced order request: the order to store, its lines with
+// the discount each received, the coupons applied and the codes the stacking
+// rules left out. rejected holds the coupons that could not be applied, which
+// CreateOrder refuses the order for.
+type orderPricing struct {
+	order    *ingressModels.Order
+	lines    []pricedLine
+	subtotal float64
+	applied  []couponCandidate
+	dropped  []string
+	rejected []*couponCodeError
+}
+
+// buildOrderFromRequest prices the order. Every coupon resolves to the first of
+// its rules the basket and customer meet; the coupons are then stacked under
+// couponConfig.stacking. Coupons that do not resolve, or that the stacking
+// rules refuse, are rejected and the order is priced without them.
+func (o *orderService) buildOrderFromRequest(coupons []codeRules, products []ingressModels.Product, orderReq *dto.OrderReq, customerID string) (*orderPricing, error) {
 	if len(products) != len(orderReq.Items) {
 		return nil, fmt.Errorf("number of products does not match order items")
 	}
@@ -146,33 +541,214 @@ func (o *orderService) buildOrderFromRequest(discountPercentage float32, product
 	}
 
 	order := &ingressModels.Order{
-		CouponCode: orderReq.CouponCode,
+		CustomerID: customerID,
 		Items:      []ingressModels.Item{},
 	}
+	pricing := &orderPricing{
+		order: order,
+		lines: make([]pricedLine, 0, len(orderReq.Items)),
+	}
 
-	var totalPrice float64
 	for _, item := range orderReq.Items {
 		product, ok := productMap[item.ProductID]
 		if !ok {
 			return nil, fmt.Errorf("product with ID %d not found", item.ProductID)
 		}
 
-		totalPrice += product.Price * float64(item.Quantity)
+		line := pricedLine{product: product, quantity: item.Quantity}
+		pricing.subtotal += line.subtotal()
+		pricing.lines = append(pricing.lines, line)
 		order.Items = append(order.Items, ingressModels.Item{
+			ProductID:   item.ProductID,
+			ProductName: product.Name,
+			UnitPrice:   product.Price,
+			Quantity:    item.Quantity,
+			Subtotal:    utils.RoundFloat64(line.subtotal(), 2),
+		})
+	}
+
+	candidates := make([]couponCandidate, 0, len(coupons))
+	now := time.Now()
+	segments := o.couponServicePorts.Segments(customerID)
+	for _, coupon := range coupons {
+		candidate, err := resolveCoupon(coupon, pricing.lines, pricing.subtotal, customerID, segments, now)
+		if err != nil {
+			pricing.rejected = append(pricing.rejected, err)
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	for len(candidates) > 0 {
+		lines := append([]pricedLine(nil), pricing.lines...)
+		applied, dropped, err := o.stackCoupons(candidates, lines, pricing.subtotal)
+		if err == nil {
+			pricing.lines, pricing.applied, pricing.dropped = lines, applied, dropped
+			break
+		}
+
+		var codeErr *couponCodeError
+		if !errors.As(err, &codeErr) {
+			return nil, err
+		}
+		pricing.rejected = append(pricing.rejected, codeErr)
+		candidates = slices.DeleteFunc(candidates, func(candidate couponCandidate) bool {
+			return candidate.code == codeErr.code
+		})
+	}
+
+	for _, coupon := range pricing.applied {
+		discount := utils.RoundFloat64(coupon.discount, 2)
+		order.Discount += discount
+		order.Discounts = append(order.Discounts, ingressModels.CouponRedemption{
+			CustomerID: customerID,
+			CouponCode: coupon.code,
+			CouponRule: coupon.rule.Name,
+			Type:       coupon.rule.Type,
+			Discount:   discount,
+		})
+	}
+
+	order.Discount = utils.RoundFloat64(order.Discount, 2)
+	order.Total = utils.RoundFloat64(pricing.subtotal-order.Discount, 2)
+	pricing.allocateLineDiscounts()
+
+	return pricing, nil
+}
+
+// allocateLineDiscounts records on every order item the discount its line
+// received, rounded to the cent. The rounding difference with the order
+// discount goes to the most discounted line, so the items add up to it.
+func (p *orderPricing) allocateLineDiscounts() {
+	var (
+		allocated float64
+		largest   int
+	)
+	for i, line := range p.lines {
+		p.order.Items[i].Discount = utils.RoundFloat64(line.discount, 2)
+		allocated += p.order.Items[i].Discount
+		if p.order.Items[i].Discount > p.order.Items[largest].Discount {
+			largest = i
+		}
+	}
+
+	if diff := utils.RoundFloat64(p.order.Discount-allocated, 2); diff != 0 && len(p.lines) > 0 {
+		p.order.Items[largest].Discount = utils.RoundFloat64(p.order.Items[largest].Discount+diff, 2)
+	}
+}
+
+// quote returns the pricing as a quote, listing rejected as the coupons left
+// out.
+func (p *orderPricing) quote(rejected []*couponCodeError) *ingressModels.OrderQuote {
+	quote := &ingressModels.OrderQuote{
+		CustomerID: p.order.CustomerID,
+		Lines:      make([]ingressModels.QuoteLine, 0, len(p.lines)),
+		Subtotal:   utils.RoundFloat64(p.subtotal, 2),
+		Discount:   p.order.Discount,
+		Total:      p.order.Total,
+	}
+
+	for i, item := range p.order.Items {
+		quote.Lines = append(quote.Lines, ingressModels.QuoteLine{
 			ProductID: item.ProductID,
+			Name:      item.ProductName,
+			Category:  p.lines[i].product.Category,
+			UnitPrice: item.UnitPrice,
 			Quantity:  item.Quantity,
+			Subtotal:  item.Subtotal,
+			Discount:  item.Discount,
+			Total:     utils.RoundFloat64(item.Subtotal-item.Discount, 2),
 		})
 	}
 
-	order.Total = totalPrice
+	for _, discount := range p.order.Discounts {
+		quote.Discounts = append(quote.Discounts, ingressModels.QuoteDiscount{
+			CouponCode: discount.CouponCode,
+			CouponRule: discount.CouponRule,
+			Type:       discount.Type,
+			Discount:   discount.Discount,
+		})
+	}
 
-	if discountPercentage > 0 {
-		discountAmount := utils.RoundFloat64((totalPrice * float64(discountPercentage) / 100), 2)
-		order.Discounts = &discountAmount
-		order.Total -= discountAmount
+	for _, err := range rejected {
+		quote.RejectedCoupons = append(quote.RejectedCoupons, ingressModels.CouponRejection{
+			CouponCode: err.code,
+			Code:       couponErrorCodes[err.err],
+			Error:      err.err.Error(),
+		})
 	}
+	return quote
+}
 
-	order.Total = utils.RoundFloat64(order.Total, 2)
+// resolveCoupon picks the first rule of coupon the customer and the basket
+// meet, along with the discount it grants on the basket alone.
+func resolveCoupon(coupon codeRules, lines []pricedLine, basketTotal float64, customerID string, segments []string, now time.Time) (couponCandidate, *couponCodeError) {
+	// ruleErr is the first reason a rule was skipped before its conditions
+	// were even checked.
+	var ruleErr error
+	for i := range coupon.rules {
+		rule := &coupon.rules[i]
+		err := ruleCustomerError(rule, customerID, segments)
+		if err == nil {
+			err = ruleWindowError(rule, now)
+		}
+		if err != nil {
+			if ruleErr == nil {
+				ruleErr = err
+			}
+			continue
+		}
 
-	return order, nil
+		discount, ok := applyCouponRule(rule, lines, basketTotal)
+		if !ok {
+			continue
+		}
+		return couponCandidate{code: coupon.code, rule: rule, discount: discount}, nil
+	}
+
+	if ruleErr == nil {
+		ruleErr = utils.ErrCouponNotApplicable
+	}
+	return couponCandidate{}, &couponCodeError{code: coupon.code, err: ruleErr}
+}
+
+var couponErrorCodes = map[error]constants.ErrorCode{
+	utils.ErrCouponNotFound:        constants.ERR_COUPON_NOT_FOUND,
+	utils.ErrCouponNotApplicable:   constants.ERR_COUPON_NOT_APPLICABLE,
+	utils.ErrCouponNotActive:       constants.ERR_COUPON_NOT_ACTIVE,
+	utils.ErrCouponExpired:         constants.ERR_COUPON_EXPIRED,
+	utils.ErrCouponExhausted:       constants.ERR_COUPON_EXHAUSTED,
+	utils.ErrCouponAlreadyRedeemed: constants.ERR_COUPON_ALREADY_REDEEMED,
+	utils.ErrCouponRevoked:         constants.ERR_COUPON_REVOKED,
+	utils.ErrCouponNotEligible:     constants.ERR_COUPON_NOT_ELIGIBLE,
+	utils.ErrCouponNotStackable:    constants.ERR_COUPON_NOT_STACKABLE,
+	utils.ErrCouponsNotReady:       constants.ERR_COUPONS_NOT_READY,
+}
+
+func isCouponError(err error) bool {
+	var codeErr *couponCodeError
+	if errors.As(err, &codeErr) {
+		err = codeErr.err
+	}
+	_, ok := couponErrorCodes[err]
+	return ok
+}
+
+func writeCouponError(ctx *fasthttp.RequestCtx, err error) {
+	status := fasthttp.StatusBadRequest
+	if errors.Is(err, utils.ErrCouponExhausted) || errors.Is(err, utils.ErrCouponAlreadyRedeemed) {
+		status = fasthttp.StatusConflict
+	}
+
+	body := map[string]any{"error": err.Error()}
+	var codeErr *couponCodeError
+	if errors.As(err, &codeErr) {
+		err = codeErr.err
+		body["couponCode"] = codeErr.code
+	}
+	body["code"] = couponErrorCodes[err]
+
+	responseBody, _ := json.Marshal(body)
+	ctx.SetStatusCode(status)
+	ctx.SetBody(responseBody)
 }
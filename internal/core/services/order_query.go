@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultOrderLimit = 20
+	maxOrderLimit     = 100
+)
+
+// GetOrder returns an order placed with the caller's API key, with its items,
+// their products and its discounts. Orders of other keys are not found.
+func (o *orderService) GetOrder(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("GetOrder"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	orderId, found := utils.PathParamValue[int64](ctx, "orderId")
+	if !found || orderId <= 0 {
+		logger.Error("invalid orderId", zap.Any("orderId", ctx.UserValue("orderId")))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"orderId must be a valid positive integer"}`)
+		return
+	}
+
+	clientID, ok := orderOwner(ctx)
+	if !ok {
+		return
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	order, err := o.orderRepository.GetOrder(dbCtx, orderId)
+	if err == nil && order.ClientID != clientID {
+		err = utils.ErrNoData
+	}
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.SetBodyString(`{"error":"order not found"}`)
+			return
+		}
+		logger.Error("failed to get order", zap.Error(err), zap.Int64("orderId", orderId))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	orders := []ingressModels.Order{*order}
+	if err := o.attachProducts(dbCtx, orders); err != nil {
+		logger.Error("failed to fetch order products", zap.Error(err), zap.Int64("orderId", orderId))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	responseBody, _ := json.Marshal(orders[0])
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+// ListOrders returns the orders placed with the caller's API key, most recent
+// first, a page of limit orders at a time. The page after is requested with the
+// nextCursor of the response. from (inclusive) and to (exclusive) bound the
+// placing time, as RFC 3339 timestamps, status keeps the orders in a status
+// and couponCode the orders the code was applied to.
+func (o *orderService) ListOrders(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("ListOrders"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	clientID, ok := orderOwner(ctx)
+	if !ok {
+		return
+	}
+
+	filter, err := o.orderFilter(ctx, clientID)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// One order more than the page tells whether there is a page after it.
+	limit := filter.Limit
+	filter.Limit++
+	orders, err := o.orderRepository.ListOrders(dbCtx, filter)
+	if err != nil {
+		logger.Error("failed to list orders", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	var nextCursor string
+	if len(orders) > limit {
+		orders = orders[:limit]
+		nextCursor = encodeOrderCursor(orders[limit-1].Id)
+	}
+
+	if err := o.attachProducts(dbCtx, orders); err != nil {
+		logger.Error("failed to fetch order products", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	response := map[string]any{
+		"count":  len(orders),
+		"orders": orders,
+	}
+	if nextCursor != "" {
+		response["nextCursor"] = nextCursor
+	}
+	responseBody, _ := json.Marshal(response)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+// orderOwner returns the client ID of the API key of ctx, the orders of which
+// the caller may read and cancel. Without API keys every order would share the
+// empty client ID, so the request is refused with 403 and false is returned.
+func orderOwner(ctx *fasthttp.RequestCtx) (string, bool) {
+	clientID, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	if clientID == "" {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBodyString(`{"error":"orders are only served to API keys"}`)
+		return "", false
+	}
+	return clientID, true
+}
+
+// orderFilter reads the query arguments of ListOrders for the orders of
+// clientID.
+func (o *orderService) orderFilter(ctx *fasthttp.RequestCtx, clientID string) (models.OrderFilter, error) {
+	args := ctx.QueryArgs()
+	filter := models.OrderFilter{Limit: defaultOrderLimit, ClientID: clientID}
+
+	if args.Has("limit") {
+		n, err := args.GetUint("limit")
+		if err != nil || n < 1 || n > maxOrderLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxOrderLimit)
+		}
+		filter.Limit = n
+	}
+
+	if raw := args.Peek("cursor"); len(raw) > 0 {
+		id, ok := decodeOrderCursor(string(raw))
+		if !ok {
+			return filter, fmt.Errorf("invalid cursor")
+		}
+		filter.AfterID = id
+	}
+
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := args.Peek(bound.name)
+		if len(raw) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, string(raw))
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+		}
+		*bound.t = t
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	if raw := args.Peek("status"); len(raw) > 0 {
+		filter.Status = constants.OrderStatus(raw)
+		if !filter.Status.IsValid() {
+			return filter, fmt.Errorf("invalid order status")
+		}
+	}
+
+	if raw := args.Peek("couponCode"); len(raw) > 0 {
+		filter.CouponCode = utils.NormalizeCode(utils.Sanitize(string(raw)), o.config.CouponConfig.Validation)
+	}
+	return filter, nil
+}
+
+// attachProducts fills in the product of every item of orders.
+func (o *orderService) attachProducts(ctx context.Context, orders []ingressModels.Order) error {
+	var productIds []int64
+	seen := make(map[int64]bool)
+	for _, order := range orders {
+		for _, item := range order.Items {
+			if !seen[item.ProductID] {
+				seen[item.ProductID] = true
+				productIds = append(productIds, item.ProductID)
+			}
+		}
+	}
+	if len(productIds) == 0 {
+		return nil
+	}
+
+	products, err := o.productRepository.ListProductsByIds(ctx, productIds)
+	if err != nil {
+		return err
+	}
+
+	productMap := make(map[int64]*ingressModels.Product, len(products))
+	for i := range products {
+		productMap[products[i].ID] = &products[i]
+	}
+	for i := range orders {
+		for j := range orders[i].Items {
+			orders[i].Items[j].Product = productMap[orders[i].Items[j].ProductID]
+		}
+	}
+	return nil
+}
+
+// Cursors are opaque to clients: the ID of the last order of a page.
+
+func encodeOrderCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeOrderCursor(cursor string) (int64, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
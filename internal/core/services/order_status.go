@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress/dto"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+// UpdateOrderStatus lets a customer cancel an order placed with their API key,
+// while its lifecycle allows it, and returns the order with its status
+// history. Trusted API keys act as staff and may make any move, so the
+// lifecycle works without admin API keys.
+func (o *orderService) UpdateOrderStatus(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("UpdateOrderStatus"), zap.String(constants.CtxRequestID.String(), requestId))
+
+	trusted, _ := utils.CtxValue[bool](ctx, constants.CtxTrusted)
+	o.updateOrderStatus(ctx, logger, trusted)
+}
+
+// StaffUpdateOrderStatus moves any order to the status of the payload, when its
+// lifecycle allows it, and returns the order with its status history. It is
+// served behind the admin API keys.
+func (o *orderService) StaffUpdateOrderStatus(ctx *fasthttp.RequestCtx) {
+	requestId, _ := utils.CtxValue[string](ctx, constants.CtxRequestID)
+	logger := o.logger.With(zap.Namespace("StaffUpdateOrderStatus"), zap.String(constants.CtxRequestID.String(), requestId))
+	o.updateOrderStatus(ctx, logger, true)
+}
+
+// updateOrderStatus moves an order to the status of the payload. Unless staff,
+// the caller may only cancel its own orders. Cancelling or rejecting an order
+// gives its coupon redemptions back.
+func (o *orderService) updateOrderStatus(ctx *fasthttp.RequestCtx, logger ports.LoggerPorts, staff bool) {
+	orderId, found := utils.PathParamValue[int64](ctx, "orderId")
+	if !found || orderId <= 0 {
+		logger.Error("invalid orderId", zap.Any("orderId", ctx.UserValue("orderId")))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"orderId must be a valid positive integer"}`)
+		return
+	}
+
+	var payload dto.OrderStatusReq
+	if err := json.Unmarshal(ctx.PostBody(), &payload); err != nil {
+		logger.Error("invalid request payload", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error":"invalid request payload"}`)
+		return
+	}
+
+	payload.Sanitize(constants.UPDATE)
+	if err := payload.Validate(); err != nil {
+		logger.Error("validation failed", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+
+	if !staff && payload.Status != constants.OrderCancelled {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBodyString(`{"error":"customers can only cancel orders"}`)
+		return
+	}
+
+	clientID, _ := utils.CtxValue[string](ctx, constants.CtxClientID)
+	if !staff {
+		if _, ok := orderOwner(ctx); !ok {
+			return
+		}
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	order, err := o.orderRepository.GetOrder(dbCtx, orderId)
+	if err == nil && !staff && order.ClientID != clientID {
+		err = utils.ErrNoData
+	}
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			ctx.SetBodyString(`{"error":"order not found"}`)
+			return
+		}
+		logger.Error("failed to get order", zap.Error(err), zap.Int64("orderId", orderId))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	if !order.Status.CanMoveTo(payload.Status) {
+		logger.Warn("illegal order status transition",
+			zap.Int64("orderId", orderId),
+			zap.String("from", order.Status.String()),
+			zap.String("to", payload.Status.String()),
+		)
+		responseBody, _ := json.Marshal(map[string]any{
+			"error":   fmt.Sprintf("order cannot go from %s to %s", order.Status, payload.Status),
+			"code":    constants.ERR_ORDER_STATUS_TRANSITION,
+			"status":  order.Status,
+			"allowed": order.Status.Next(),
+		})
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.SetBody(responseBody)
+		return
+	}
+
+	change := &ingressModels.OrderStatusChange{
+		FromStatus: order.Status,
+		ToStatus:   payload.Status,
+		Reason:     payload.Reason,
+		ChangedBy:  clientID,
+	}
+	if err := o.orderRepository.UpdateOrderStatus(dbCtx, orderId, change); err != nil {
+		if errors.Is(err, utils.ErrOrderStatusConflict) {
+			logger.Warn("order status changed concurrently", zap.Int64("orderId", orderId))
+			ctx.SetStatusCode(fasthttp.StatusConflict)
+			ctx.SetBodyString(fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+			return
+		}
+		logger.Error("failed to update order status", zap.Error(err), zap.Int64("orderId", orderId))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	logger.Info("order status changed",
+		zap.Int64("orderId", orderId),
+		zap.String("from", change.FromStatus.String()),
+		zap.String("to", change.ToStatus.String()),
+	)
+
+	if payload.Status == constants.OrderCancelled || payload.Status == constants.OrderRejected {
+		o.releaseOrderCoupons(logger, order)
+	}
+
+	order.Status = change.ToStatus
+	order.StatusHistory = append(order.StatusHistory, *change)
+
+	responseBody, _ := json.Marshal(order)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+// releaseOrderCoupons reverts the redemptions of the coupons applied to order,
+// so they count against no limit. The redemptions stay in the order history.
+func (o *orderService) releaseOrderCoupons(logger ports.LoggerPorts, order *ingressModels.Order) {
+	applied := make([]couponCandidate, 0, len(order.Discounts))
+	for _, discount := range order.Discounts {
+		applied = append(applied, couponCandidate{code: discount.CouponCode})
+	}
+	o.releaseCoupons(logger, applied, order.CustomerID)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	ingressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/ingress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"github.com/bhupendra-dudhwal/kart-challenge/pkg/logger"
+
+	"github.com/valyala/fasthttp"
+)
+
+// statusOrderRepository holds a single order, placed by client "owner".
+type statusOrderRepository struct {
+	egressPorts.OrderRepository
+	order *ingressModels.Order
+}
+
+func (r *statusOrderRepository) GetOrder(ctx context.Context, id int64) (*ingressModels.Order, error) {
+	if r.order == nil || r.order.Id != id {
+		return nil, utils.ErrNoData
+	}
+	order := *r.order
+	return &order, nil
+}
+
+func (r *statusOrderRepository) UpdateOrderStatus(ctx context.Context, id int64, change *ingressModels.OrderStatusChange) error {
+	if r.order.Status != change.FromStatus {
+		return utils.ErrOrderStatusConflict
+	}
+	r.order.Status = change.ToStatus
+	return nil
+}
+
+// releaseCouponService records the coupons released.
+type releaseCouponService struct {
+	ingressPorts.CouponServicePorts
+	released []string
+}
+
+func (c *releaseCouponService) Release(ctx context.Context, code, customerID string) error {
+	c.released = append(c.released, code)
+	return nil
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		from         constants.OrderStatus
+		to           constants.OrderStatus
+		clientID     string
+		trusted      bool
+		wantCode     int
+		wantStatus   constants.OrderStatus
+		wantReleased bool
+	}{
+		{name: "staff accepts a placed order", from: constants.OrderPlaced, to: constants.OrderAccepted, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderAccepted},
+		{name: "staff rejects a placed order", from: constants.OrderPlaced, to: constants.OrderRejected, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderRejected, wantReleased: true},
+		{name: "staff prepares an accepted order", from: constants.OrderAccepted, to: constants.OrderPreparing, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderPreparing},
+		{name: "staff readies a preparing order", from: constants.OrderPreparing, to: constants.OrderReady, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderReady},
+		{name: "staff completes a ready order", from: constants.OrderReady, to: constants.OrderCompleted, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderCompleted},
+		{name: "staff cannot skip to completed", from: constants.OrderPlaced, to: constants.OrderCompleted, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderPlaced},
+		{name: "staff cannot reject an accepted order", from: constants.OrderAccepted, to: constants.OrderRejected, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderAccepted},
+		{name: "staff cannot cancel a ready order", from: constants.OrderReady, to: constants.OrderCancelled, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderReady},
+		{name: "staff cannot reopen a completed order", from: constants.OrderCompleted, to: constants.OrderPlaced, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderCompleted},
+		{name: "staff cannot move a cancelled order", from: constants.OrderCancelled, to: constants.OrderAccepted, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderCancelled},
+		{name: "staff cannot move a rejected order", from: constants.OrderRejected, to: constants.OrderPlaced, clientID: "staff", trusted: true, wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderRejected},
+		{name: "staff may act on any order", from: constants.OrderPlaced, to: constants.OrderCancelled, clientID: "staff", trusted: true, wantCode: fasthttp.StatusOK, wantStatus: constants.OrderCancelled, wantReleased: true},
+		{name: "customer cancels a placed order", from: constants.OrderPlaced, to: constants.OrderCancelled, clientID: "owner", wantCode: fasthttp.StatusOK, wantStatus: constants.OrderCancelled, wantReleased: true},
+		{name: "customer cancels a preparing order", from: constants.OrderPreparing, to: constants.OrderCancelled, clientID: "owner", wantCode: fasthttp.StatusOK, wantStatus: constants.OrderCancelled, wantReleased: true},
+		{name: "customer cannot cancel a ready order", from: constants.OrderReady, to: constants.OrderCancelled, clientID: "owner", wantCode: fasthttp.StatusConflict, wantStatus: constants.OrderReady},
+		{name: "customer cannot accept an order", from: constants.OrderPlaced, to: constants.OrderAccepted, clientID: "owner", wantCode: fasthttp.StatusForbidden, wantStatus: constants.OrderPlaced},
+		{name: "customer cannot cancel another customer's order", from: constants.OrderPlaced, to: constants.OrderCancelled, clientID: "other", wantCode: fasthttp.StatusNotFound, wantStatus: constants.OrderPlaced},
+		{name: "caller without an API key cannot cancel", from: constants.OrderPlaced, to: constants.OrderCancelled, wantCode: fasthttp.StatusForbidden, wantStatus: constants.OrderPlaced},
+	}
+
+	log, err := logger.NewLogger(constants.LERROR, constants.ENV_PRODUCTION)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &statusOrderRepository{order: &ingressModels.Order{
+				Id:        1,
+				ClientID:  "owner",
+				Status:    tt.from,
+				Discounts: []ingressModels.CouponRedemption{{CouponCode: "HAPPYHRS"}},
+			}}
+			coupons := &releaseCouponService{}
+			service := &orderService{
+				config:             &models.Config{},
+				logger:             log,
+				orderRepository:    repository,
+				couponServicePorts: coupons,
+			}
+
+			body, _ := json.Marshal(map[string]any{"status": tt.to})
+			var request fasthttp.Request
+			request.SetBody(body)
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Init(&request, nil, nil)
+			ctx.SetUserValue("orderId", "1")
+			ctx.SetUserValue(constants.CtxClientID, tt.clientID)
+			ctx.SetUserValue(constants.CtxTrusted, tt.trusted)
+
+			service.UpdateOrderStatus(ctx)
+
+			if got := ctx.Response.StatusCode(); got != tt.wantCode {
+				t.Errorf("status code = %d, want %d: %s", got, tt.wantCode, ctx.Response.Body())
+			}
+			if repository.order.Status != tt.wantStatus {
+				t.Errorf("order status = %s, want %s", repository.order.Status, tt.wantStatus)
+			}
+			if released := len(coupons.released) > 0; released != tt.wantReleased {
+				t.Errorf("coupons released = %v, want %v", released, tt.wantReleased)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
+	ingressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/ingress"
+
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+type readinessService struct {
+	config *models.Config
+	logger ports.LoggerPorts
+
+	mu           sync.RWMutex
+	couponStatus models.CouponIngestionStatus
+	couponStats  func() models.CouponVerificationStats
+}
+
+func NewReadinessService(config *models.Config, logger ports.LoggerPorts) ingressPorts.ReadinessServicePorts {
+	return &readinessService{
+		config: config,
+		logger: logger,
+		couponStatus: models.CouponIngestionStatus{
+			State: constants.IngestionPending,
+		},
+	}
+}
+
+// Readyz answers 200 as soon as the server is up: products and coupon-less
+// orders are served while the coupons load. Coupon readiness is reported in
+// the body, so a slow or failed load never takes the replica out of rotation,
+// along with the coupon verification stats of the replica.
+func (r *readinessService) Readyz(ctx *fasthttp.RequestCtx) {
+	status := r.CouponStatus()
+
+	response := map[string]any{
+		"ready":        true,
+		"couponsReady": status.State == constants.IngestionReady,
+		"coupons":      status,
+	}
+	r.mu.RLock()
+	couponStats := r.couponStats
+	r.mu.RUnlock()
+	if couponStats != nil {
+		response["couponVerification"] = couponStats()
+	}
+
+	responseBody, err := json.Marshal(response)
+	if err != nil {
+		r.logger.Error("failed to marshal readiness response", zap.Error(err))
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(`{"error":"internal server error"}`)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(responseBody)
+}
+
+func (r *readinessService) CouponsReady() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.couponStatus.State == constants.IngestionReady
+}
+
+func (r *readinessService) CouponStatus() models.CouponIngestionStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.couponStatus
+}
+
+func (r *readinessService) StartCouponLoad(files int) {
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.couponStatus = models.CouponIngestionStatus{
+		State:     constants.IngestionLoading,
+		StartedAt: &now,
+		Files:     files,
+		Attempts:  r.couponStatus.Attempts + 1,
+	}
+}
+
+func (r *readinessService) UpdateCouponProgress(completedBatches, codesLoaded int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.couponStatus.CompletedBatches = completedBatches
+	r.couponStatus.CodesLoaded = codesLoaded
+}
+
+func (r *readinessService) FinishCouponLoad(err error) {
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.couponStatus.CompletedAt = &now
+	if err != nil {
+		r.couponStatus.State = constants.IngestionFailed
+		r.couponStatus.Error = err.Error()
+		return
+	}
+	r.couponStatus.State = constants.IngestionReady
+}
+
+// RetryCouponLoad records when a failed load will be attempted again.
+func (r *readinessService) RetryCouponLoad(at time.Time) {
+	at = at.UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.couponStatus.RetryAt = &at
+}
+
+// SetCouponStats sets where Readyz reads the coupon verification stats from.
+func (r *readinessService) SetCouponStats(stats func() models.CouponVerificationStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.couponStats = stats
+}
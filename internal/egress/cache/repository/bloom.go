@@ -0,0 +1,346 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
+	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
+	"github.com/bhupendra-dudhwal/kart-challenge/pkg/bloom"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// maxLoadedFilters bounds how many filters are kept in memory; older ones are
+// reloaded from the store on demand.
+const maxLoadedFilters = 4
+
+// nativeBloomRepository serves the Bloom filter methods from in-process
+// filters, persisted as plain Redis strings or files, and delegates everything
+// else to the wrapped repository.
+type nativeBloomRepository struct {
+	egressPorts.CacheRepository
+
+	config *models.CouponBloom
+
+	mu      sync.RWMutex
+	loads   singleflight.Group
+	filters map[string]*bloom.Filter
+	loaded  []string
+	// unsaved filters are being built and are never evicted before BFSave.
+	unsaved map[string]bool
+	// revisions holds the stored revision each filter was loaded at, checked
+	// when it was last compared with the store.
+	revisions map[string]int64
+	checked   map[string]time.Time
+}
+
+func NewNativeBloomRepository(config *models.CouponBloom, cacheRepository egressPorts.CacheRepository) egressPorts.CacheRepository {
+	return &nativeBloomRepository{
+		CacheRepository: cacheRepository,
+		config:          config,
+		filters:         make(map[string]*bloom.Filter),
+		unsaved:         make(map[string]bool),
+		revisions:       make(map[string]int64),
+		checked:         make(map[string]time.Time),
+	}
+}
+
+func (n *nativeBloomRepository) BFMAdd(ctx context.Context, filterName string, values ...string) error {
+	filter, err := n.filter(ctx, filterName, true)
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		filter.Add(value)
+	}
+	return nil
+}
+
+// AddCodesIfLocked adds codes to the exact set through the wrapped repository,
+// fenced by the lock, and to the in-process filter only once that succeeded.
+func (n *nativeBloomRepository) AddCodesIfLocked(ctx context.Context, fence models.CouponLeaseFence, bloomKey, exactKey string, codes []string) (bool, error) {
+	ok, err := n.CacheRepository.AddCodesIfLocked(ctx, fence, "", exactKey, codes)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, n.BFMAdd(ctx, bloomKey, codes...)
+}
+
+// BFReserve creates filterName with explicit sizing unless it already exists.
+// Native filters do not scale, so expansion is ignored.
+func (n *nativeBloomRepository) BFReserve(ctx context.Context, filterName string, errorRate float64, capacity uint64, expansion int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.filters[filterName]; ok {
+		return nil
+	}
+	n.add(filterName, bloom.New(capacity, errorRate))
+	n.unsaved[filterName] = true
+	return nil
+}
+
+func (n *nativeBloomRepository) BFInfo(ctx context.Context, filterName string) (*models.BloomInfo, error) {
+	filter, err := n.filter(ctx, filterName, false)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, utils.ErrNoData
+	}
+	return &models.BloomInfo{
+		Capacity:      int64(filter.Capacity()),
+		Size:          int64(filter.Size()),
+		Filters:       1,
+		ItemsInserted: int64(filter.Count()),
+	}, nil
+}
+
+func (n *nativeBloomRepository) BFExists(ctx context.Context, filterName string, value string) (bool, error) {
+	filter, err := n.filter(ctx, filterName, false)
+	if err != nil {
+		return false, err
+	}
+	if filter == nil {
+		return false, nil
+	}
+	return filter.Test(value), nil
+}
+
+func (n *nativeBloomRepository) BFSave(ctx context.Context, filterName string) error {
+	filter, err := n.filter(ctx, filterName, true)
+	if err != nil {
+		return err
+	}
+
+	raw, err := filter.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if n.config.Store == constants.BloomStoreFile {
+		err = n.writeFile(filterName, raw)
+	} else {
+		err = n.CacheRepository.Set(ctx, filterName, raw, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	revision, err := n.CacheRepository.Incr(ctx, revisionKey(filterName))
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	delete(n.unsaved, filterName)
+	n.revisions[filterName] = revision
+	n.checked[filterName] = time.Now()
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *nativeBloomRepository) Del(ctx context.Context, keys ...string) error {
+	n.mu.Lock()
+	for _, key := range keys {
+		n.evict(key)
+	}
+	n.mu.Unlock()
+
+	revisionKeys := make([]string, len(keys))
+	for i, key := range keys {
+		revisionKeys[i] = revisionKey(key)
+	}
+	keys = append(keys, revisionKeys...)
+
+	if n.config.Store == constants.BloomStoreFile {
+		for _, key := range keys {
+			if err := os.Remove(n.filePath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete bloom filter file: %w", err)
+			}
+		}
+	}
+	return n.CacheRepository.Del(ctx, keys...)
+}
+
+// filter returns the filter named filterName, loading it from the store when it
+// is not in memory or was saved again since, by another replica say. A missing
+// filter is created when create is set and nil is returned otherwise. The
+// store is only read outside the lock, one load per filter at a time, so
+// lookups of loaded filters never wait on Redis.
+func (n *nativeBloomRepository) filter(ctx context.Context, filterName string, create bool) (*bloom.Filter, error) {
+	n.mu.RLock()
+	filter, ok := n.filters[filterName]
+	due := ok && !n.unsaved[filterName] && time.Since(n.checked[filterName]) >= n.config.RefreshInterval
+	n.mu.RUnlock()
+	if ok && !due {
+		return filter, nil
+	}
+
+	loaded, err, _ := n.loads.Do(filterName+":"+strconv.FormatBool(create), func() (any, error) {
+		return n.load(ctx, filterName, filter, create)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loaded.(*bloom.Filter), nil
+}
+
+// load reads filterName from the store, unless its revision shows that loaded,
+// the filter in memory if any, is still the stored one, and swaps it in. A
+// filter reserved or loaded by someone else meanwhile wins over the one read.
+func (n *nativeBloomRepository) load(ctx context.Context, filterName string, loaded *bloom.Filter, create bool) (*bloom.Filter, error) {
+	// The revision is read first: a save racing with the read leaves a newer
+	// filter under an older revision, which is simply reloaded once more.
+	revision, err := n.revision(ctx, filterName)
+	if err != nil {
+		return nil, err
+	}
+
+	if loaded != nil {
+		n.mu.Lock()
+		if n.filters[filterName] == loaded && n.revisions[filterName] == revision {
+			n.checked[filterName] = time.Now()
+			n.mu.Unlock()
+			return loaded, nil
+		}
+		n.mu.Unlock()
+	}
+
+	raw, err := n.read(ctx, filterName)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter *bloom.Filter
+	if raw != nil {
+		filter = &bloom.Filter{}
+		if err := filter.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("failed to decode bloom filter %s: %w", filterName, err)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if current, ok := n.filters[filterName]; ok {
+		if current != loaded {
+			return current, nil
+		}
+		n.evict(filterName)
+	}
+
+	switch {
+	case filter != nil:
+	case create:
+		filter = bloom.New(n.config.Capacity, n.config.ErrorRate)
+		n.unsaved[filterName] = true
+	default:
+		return nil, nil
+	}
+
+	n.add(filterName, filter)
+	n.revisions[filterName] = revision
+	n.checked[filterName] = time.Now()
+	return filter, nil
+}
+
+// revision returns the number of times filterName was saved, 0 if never.
+func (n *nativeBloomRepository) revision(ctx context.Context, filterName string) (int64, error) {
+	raw, err := n.CacheRepository.Get(ctx, revisionKey(filterName))
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// revisionKey is the Redis counter bumped every time filterName is saved.
+func revisionKey(filterName string) string {
+	return filterName + ":rev"
+}
+
+// add keeps filter in memory, evicting the oldest saved filters beyond
+// maxLoadedFilters.
+func (n *nativeBloomRepository) add(filterName string, filter *bloom.Filter) {
+	n.filters[filterName] = filter
+	n.loaded = append(n.loaded, filterName)
+	for i := 0; len(n.loaded) > maxLoadedFilters && i < len(n.loaded)-1; {
+		if name := n.loaded[i]; !n.unsaved[name] {
+			n.evict(name)
+			continue
+		}
+		i++
+	}
+}
+
+func (n *nativeBloomRepository) evict(filterName string) {
+	delete(n.filters, filterName)
+	delete(n.unsaved, filterName)
+	delete(n.revisions, filterName)
+	delete(n.checked, filterName)
+	n.loaded = slices.DeleteFunc(n.loaded, func(name string) bool { return name == filterName })
+}
+
+func (n *nativeBloomRepository) read(ctx context.Context, filterName string) ([]byte, error) {
+	if n.config.Store == constants.BloomStoreFile {
+		raw, err := os.ReadFile(n.filePath(filterName))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to read bloom filter file: %w", err)
+		}
+		return raw, nil
+	}
+
+	raw, err := n.CacheRepository.Get(ctx, filterName)
+	if err != nil {
+		if errors.Is(err, utils.ErrNoData) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []byte(raw), nil
+}
+
+// writeFile replaces the filter file atomically so readers never see a
+// partial filter.
+func (n *nativeBloomRepository) writeFile(filterName string, raw []byte) error {
+	if err := os.MkdirAll(n.config.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create bloom filter dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(n.config.Dir, filepath.Base(n.filePath(filterName))+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write bloom filter file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write bloom filter file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write bloom filter file: %w", err)
+	}
+	return os.Rename(tmp.Name(), n.filePath(filterName))
+}
+
+func (n *nativeBloomRepository) filePath(filterName string) string {
+	return filepath.Join(n.config.Dir, filterName+".bloom")
+}
@@ -2,12 +2,107 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
 	"github.com/redis/go-redis/v9"
 )
 
+// redeemScript increments the global and per-customer redemption counters of
+// a coupon only if neither limit (0 = unlimited) would be exceeded. Without a
+// customer key (KEYS[2]) only the global limit applies.
+var redeemScript = redis.NewScript(`
+local total = tonumber(redis.call('GET', KEYS[1]) or '0')
+local customer = 0
+if #KEYS > 1 then
+	customer = tonumber(redis.call('GET', KEYS[2]) or '0')
+end
+local maxTotal = tonumber(ARGV[1])
+local maxCustomer = tonumber(ARGV[2])
+if maxTotal > 0 and total >= maxTotal then
+	return -1
+end
+if maxCustomer > 0 and customer >= maxCustomer then
+	return -2
+end
+redis.call('INCR', KEYS[1])
+if #KEYS > 1 then
+	redis.call('INCR', KEYS[2])
+end
+return 1
+`)
+
+// renewLockScript extends a lock only while it is still held by value.
+var renewLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLockScript deletes a lock only while it is still held by value.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// setIfLockedScript writes KEYS[3] only while the lock KEYS[1] is held by
+// ARGV[1] and the fencing counter KEYS[2] is still at ARGV[2], rejecting
+// writes from a holder whose lease already expired, even when the lock was
+// since taken again under the same value.
+var setIfLockedScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] or redis.call('GET', KEYS[2]) ~= ARGV[2] then
+	return 0
+end
+redis.call('SET', KEYS[3], ARGV[3])
+return 1
+`)
+
+// compareAndSetScript replaces KEYS[1] with ARGV[2], expiring in ARGV[3]
+// milliseconds, only while it still holds ARGV[1].
+var compareAndSetScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// addCodesIfLockedScript adds ARGV[3..] to the exact set KEYS[3] and, when
+// given, the Bloom filter KEYS[4], only while the lock KEYS[1] is held by
+// ARGV[1] and the fencing counter KEYS[2] is still at ARGV[2]. Codes are sent
+// in chunks to stay within Lua's unpack limit.
+var addCodesIfLockedScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] or redis.call('GET', KEYS[2]) ~= ARGV[2] then
+	return 0
+end
+for i = 3, #ARGV, 1000 do
+	local last = math.min(i + 999, #ARGV)
+	if #KEYS > 3 then
+		redis.call('BF.MADD', KEYS[4], unpack(ARGV, i, last))
+	end
+	redis.call('SADD', KEYS[3], unpack(ARGV, i, last))
+end
+return 1
+`)
+
+// releaseScript reverts a redemption made by redeemScript.
+var releaseScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if tonumber(redis.call('GET', key) or '0') > 0 then
+		redis.call('DECR', key)
+	end
+end
+return 1
+`)
+
 type cacheRepository struct {
 	redisClient *redis.Client
 }
@@ -18,6 +113,31 @@ func NewRepository(redisClient *redis.Client) egressPorts.CacheRepository {
 	}
 }
 
+func (c *cacheRepository) Get(ctx context.Context, key string) (string, error) {
+	res, err := c.redisClient.Get(ctx, key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", utils.ErrNoData
+		}
+		return "", fmt.Errorf("redis Get error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if err := c.redisClient.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("redis Set error: %w", err)
+	}
+	return nil
+}
+
+func (c *cacheRepository) Del(ctx context.Context, keys ...string) error {
+	if err := c.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("redis Del error: %w", err)
+	}
+	return nil
+}
+
 func (c *cacheRepository) SAdd(ctx context.Context, key string, members ...interface{}) error {
 	if err := c.redisClient.SAdd(ctx, key, members...).Err(); err != nil {
 		return fmt.Errorf("redis SAdd error: %w", err)
@@ -25,6 +145,95 @@ func (c *cacheRepository) SAdd(ctx context.Context, key string, members ...inter
 	return nil
 }
 
+func (c *cacheRepository) SIsMember(ctx context.Context, key string, member string) (bool, error) {
+	res, err := c.redisClient.SIsMember(ctx, key, member).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SIsMember error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) SRem(ctx context.Context, key string, members ...interface{}) error {
+	if err := c.redisClient.SRem(ctx, key, members...).Err(); err != nil {
+		return fmt.Errorf("redis SRem error: %w", err)
+	}
+	return nil
+}
+
+func (c *cacheRepository) SMembers(ctx context.Context, key string) ([]string, error) {
+	res, err := c.redisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis SMembers error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) SScan(ctx context.Context, key string, cursor uint64, count int64) ([]string, uint64, error) {
+	res, next, err := c.redisClient.SScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis SScan error: %w", err)
+	}
+	return res, next, nil
+}
+
+func (c *cacheRepository) SCard(ctx context.Context, key string) (int64, error) {
+	res, err := c.redisClient.SCard(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis SCard error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) HSet(ctx context.Context, key, field string, value interface{}) error {
+	if err := c.redisClient.HSet(ctx, key, field, value).Err(); err != nil {
+		return fmt.Errorf("redis HSet error: %w", err)
+	}
+	return nil
+}
+
+func (c *cacheRepository) HGet(ctx context.Context, key, field string) (string, error) {
+	res, err := c.redisClient.HGet(ctx, key, field).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", utils.ErrNoData
+		}
+		return "", fmt.Errorf("redis HGet error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) HExists(ctx context.Context, key, field string) (bool, error) {
+	res, err := c.redisClient.HExists(ctx, key, field).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis HExists error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	res, err := c.redisClient.HDel(ctx, key, fields...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis HDel error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	res, err := c.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis HGetAll error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) HKeys(ctx context.Context, key string) ([]string, error) {
+	res, err := c.redisClient.HKeys(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis HKeys error: %w", err)
+	}
+	return res, nil
+}
+
 func (c *cacheRepository) Do(ctx context.Context, args []interface{}) error {
 	if err := c.redisClient.Do(ctx, args...).Err(); err != nil {
 		return fmt.Errorf("redis do error: %w", err)
@@ -39,3 +248,158 @@ func (c *cacheRepository) BFExists(ctx context.Context, filterName string, value
 	}
 	return res, nil
 }
+
+func (c *cacheRepository) BFMAdd(ctx context.Context, filterName string, values ...string) error {
+	args := make([]interface{}, 0, len(values)+2)
+	args = append(args, "BF.MADD", filterName)
+	for _, value := range values {
+		args = append(args, value)
+	}
+
+	if err := c.redisClient.Do(ctx, args...).Err(); err != nil {
+		return fmt.Errorf("redis BF.MADD error: %w", err)
+	}
+	return nil
+}
+
+// BFReserve creates filterName with explicit sizing. An existing filter, left
+// by an interrupted load being resumed, is kept as is.
+func (c *cacheRepository) BFReserve(ctx context.Context, filterName string, errorRate float64, capacity uint64, expansion int) error {
+	err := c.redisClient.BFReserveWithArgs(ctx, filterName, &redis.BFReserveOptions{
+		Capacity:  int64(capacity),
+		Error:     errorRate,
+		Expansion: int64(expansion),
+	}).Err()
+	if err != nil && !strings.Contains(err.Error(), "item exists") {
+		return fmt.Errorf("redis BF.RESERVE error: %w", err)
+	}
+	return nil
+}
+
+func (c *cacheRepository) BFInfo(ctx context.Context, filterName string) (*models.BloomInfo, error) {
+	res, err := c.redisClient.BFInfo(ctx, filterName).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, utils.ErrNoData
+		}
+		return nil, fmt.Errorf("redis BF.INFO error: %w", err)
+	}
+	return &models.BloomInfo{
+		Capacity:      res.Capacity,
+		Size:          res.Size,
+		Filters:       res.Filters,
+		ItemsInserted: res.ItemsInserted,
+		ExpansionRate: res.ExpansionRate,
+	}, nil
+}
+
+// BFSave is a no-op: RedisBloom filters are persisted by Redis itself.
+func (c *cacheRepository) BFSave(ctx context.Context, filterName string) error {
+	return nil
+}
+
+func (c *cacheRepository) RedeemCoupon(ctx context.Context, totalKey, customerKey string, maxTotal, maxPerCustomer int64) error {
+	res, err := redeemScript.Run(ctx, c.redisClient, redemptionScriptKeys(totalKey, customerKey), maxTotal, maxPerCustomer).Int64()
+	if err != nil {
+		return fmt.Errorf("redis redeem coupon error: %w", err)
+	}
+
+	switch res {
+	case -1:
+		return utils.ErrCouponExhausted
+	case -2:
+		return utils.ErrCouponAlreadyRedeemed
+	}
+	return nil
+}
+
+func (c *cacheRepository) ReleaseCoupon(ctx context.Context, totalKey, customerKey string) error {
+	if err := releaseScript.Run(ctx, c.redisClient, redemptionScriptKeys(totalKey, customerKey)).Err(); err != nil {
+		return fmt.Errorf("redis release coupon error: %w", err)
+	}
+	return nil
+}
+
+// redemptionScriptKeys leaves out the customer key when there is no customer
+// to count against.
+func redemptionScriptKeys(totalKey, customerKey string) []string {
+	if customerKey == "" {
+		return []string{totalKey}
+	}
+	return []string{totalKey, customerKey}
+}
+
+func (c *cacheRepository) Incr(ctx context.Context, key string) (int64, error) {
+	res, err := c.redisClient.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis Incr error: %w", err)
+	}
+	return res, nil
+}
+
+func (c *cacheRepository) AcquireLock(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	res, err := c.redisClient.SetArgs(ctx, key, value, redis.SetArgs{Mode: "NX", TTL: ttl}).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, fmt.Errorf("redis acquire lock error: %w", err)
+	}
+	return res == "OK", nil
+}
+
+func (c *cacheRepository) RenewLock(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	res, err := renewLockScript.Run(ctx, c.redisClient, []string{key}, value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis renew lock error: %w", err)
+	}
+	return res == 1, nil
+}
+
+func (c *cacheRepository) ReleaseLock(ctx context.Context, key, value string) error {
+	if err := releaseLockScript.Run(ctx, c.redisClient, []string{key}, value).Err(); err != nil {
+		return fmt.Errorf("redis release lock error: %w", err)
+	}
+	return nil
+}
+
+// CompareAndSet replaces key with value, expiring after ttl, only while key
+// still holds expected.
+func (c *cacheRepository) CompareAndSet(ctx context.Context, key, expected string, value interface{}, ttl time.Duration) (bool, error) {
+	res, err := compareAndSetScript.Run(ctx, c.redisClient, []string{key}, expected, value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis compare and set error: %w", err)
+	}
+	return res == 1, nil
+}
+
+// AddCodesIfLocked adds codes to the exact set exactKey and the Bloom filter
+// bloomKey (skipped when empty) only while the lease is still held under
+// fence, so a loader whose lease expired cannot write into the dataset any
+// more.
+func (c *cacheRepository) AddCodesIfLocked(ctx context.Context, fence models.CouponLeaseFence, bloomKey, exactKey string, codes []string) (bool, error) {
+	keys := []string{fence.LockKey, fence.FenceKey, exactKey}
+	if bloomKey != "" {
+		keys = append(keys, bloomKey)
+	}
+
+	args := make([]interface{}, 0, len(codes)+2)
+	args = append(args, fence.Value, fence.Token)
+	for _, code := range codes {
+		args = append(args, code)
+	}
+
+	res, err := addCodesIfLockedScript.Run(ctx, c.redisClient, keys, args...).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis fenced add codes error: %w", err)
+	}
+	return res == 1, nil
+}
+
+func (c *cacheRepository) SetIfLocked(ctx context.Context, fence models.CouponLeaseFence, key string, value interface{}) (bool, error) {
+	res, err := setIfLockedScript.Run(ctx, c.redisClient, []string{fence.LockKey, fence.FenceKey, key}, fence.Value, fence.Token, value).Int64()
+	if err != nil {
+		return false, fmt.Errorf("redis fenced set error: %w", err)
+	}
+	return res == 1, nil
+}
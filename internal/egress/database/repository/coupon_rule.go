@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
+	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
+	"gorm.io/gorm"
+)
+
+type couponRuleRepository struct {
+	client *gorm.DB
+}
+
+func NewCouponRuleRepository(client *gorm.DB) egressPorts.CouponRuleRepository {
+	return &couponRuleRepository{
+		client: client,
+	}
+}
+
+func (m *couponRuleRepository) ListCouponRules(ctx context.Context) ([]ingressModels.CouponRule, error) {
+	var rules []ingressModels.CouponRule
+	if err := m.client.WithContext(ctx).Order("priority DESC, id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m *couponRuleRepository) ListCustomerSegments(ctx context.Context) ([]ingressModels.CustomerSegment, error) {
+	var segments []ingressModels.CustomerSegment
+	if err := m.client.WithContext(ctx).Find(&segments).Error; err != nil {
+		return nil, err
+	}
+
+	return segments, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 
+	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	ingressModels "github.com/bhupendra-dudhwal/kart-challenge/internal/core/models/ingress"
 	egressPorts "github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports/egress"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/utils"
@@ -31,3 +32,93 @@ func (m *orderRepository) CreateOrder(ctx context.Context, payload *ingressModel
 	}
 	return nil
 }
+
+// GetOrder returns the order id with its items, discounts and status history.
+func (m *orderRepository) GetOrder(ctx context.Context, id int64) (*ingressModels.Order, error) {
+	var order ingressModels.Order
+	err := m.client.WithContext(ctx).
+		Preload("Items").
+		Preload("Discounts").
+		Preload("StatusHistory", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		}).
+		First(&order, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrNoData
+		}
+		return nil, err
+	}
+	return &order, nil
+}
+
+// UpdateOrderStatus moves the order id from change.FromStatus to
+// change.ToStatus and records change, in one transaction. It returns
+// ErrOrderStatusConflict when the order is no longer in change.FromStatus.
+func (m *orderRepository) UpdateOrderStatus(ctx context.Context, id int64, change *ingressModels.OrderStatusChange) error {
+	return m.client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ingressModels.Order{}).
+			Where("id = ? AND status = ?", id, change.FromStatus).
+			Update("status", change.ToStatus)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return utils.ErrOrderStatusConflict
+		}
+
+		change.OrderID = id
+		return tx.Create(change).Error
+	})
+}
+
+// ListOrders returns the orders matching filter with their items and
+// discounts, most recent first.
+func (m *orderRepository) ListOrders(ctx context.Context, filter models.OrderFilter) ([]ingressModels.Order, error) {
+	query := m.client.WithContext(ctx).Where("client_id = ?", filter.ClientID)
+	if filter.AfterID > 0 {
+		query = query.Where("id < ?", filter.AfterID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.CouponCode != "" {
+		query = query.Where("id IN (?)", m.client.Model(&ingressModels.CouponRedemption{}).
+			Select("order_id").
+			Where("coupon_code = ?", filter.CouponCode))
+	}
+
+	var orders []ingressModels.Order
+	err := query.
+		Preload("Items").
+		Preload("Discounts").
+		Order("id DESC").
+		Limit(filter.Limit).
+		Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// ListRedemptions returns the coupon redemptions of customerID, most recent
+// first, restricted to couponCode when it is set.
+func (m *orderRepository) ListRedemptions(ctx context.Context, customerID, couponCode string, limit int) ([]ingressModels.CouponRedemption, error) {
+	query := m.client.WithContext(ctx).Where("customer_id = ?", customerID)
+	if couponCode != "" {
+		query = query.Where("coupon_code = ?", couponCode)
+	}
+
+	var redemptions []ingressModels.CouponRedemption
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&redemptions).Error; err != nil {
+		return nil, err
+	}
+
+	return redemptions, nil
+}
@@ -50,5 +50,39 @@ func (h *handler) SetProductHandler(productServicePorts ingressPorts.ProductServ
 }
 
 func (h *handler) SetOrderHandler(orderServicePorts ingressPorts.OrderServicePorts) {
-	h.route.POST("/api/v1/orders", h.middlewarePorts.Authorization(orderServicePorts.CreateOrder))
+	body := h.middlewarePorts.LimitBody
+	h.route.POST("/api/v1/orders", h.middlewarePorts.Authorization(body(orderServicePorts.CreateOrder)))
+	h.route.POST("/api/v1/orders/quote", h.middlewarePorts.Authorization(body(orderServicePorts.QuoteOrder)))
+	h.route.GET("/api/v1/orders", h.middlewarePorts.Authorization(orderServicePorts.ListOrders))
+	h.route.GET("/api/v1/orders/{orderId}", h.middlewarePorts.Authorization(orderServicePorts.GetOrder))
+	h.route.PATCH("/api/v1/orders/{orderId}/status", h.middlewarePorts.Authorization(body(orderServicePorts.UpdateOrderStatus)))
+	h.route.GET("/api/v1/customers/{customerId}/redemptions", h.middlewarePorts.Authorization(orderServicePorts.ListRedemptions))
+
+	if h.config.AdminApiKey.Enabled {
+		h.route.PATCH("/api/v1/admin/orders/{orderId}/status", h.middlewarePorts.AdminAuthorization(body(orderServicePorts.StaffUpdateOrderStatus)))
+	}
+}
+
+func (h *handler) SetReadinessHandler(readinessServicePorts ingressPorts.ReadinessServicePorts) {
+	h.route.GET("/readyz", readinessServicePorts.Readyz)
+}
+
+// SetCouponHandler registers the coupon admin endpoints, which are only served
+// when admin API keys are enabled. The upload endpoint reads its body as a
+// stream, capped by couponConfig.maxUploadSize; every other endpoint reading a
+// body buffers it through LimitBody.
+func (h *handler) SetCouponHandler(couponServicePorts ingressPorts.CouponServicePorts, couponAdminServicePorts ingressPorts.CouponAdminServicePorts) {
+	if !h.config.AdminApiKey.Enabled {
+		return
+	}
+
+	admin := h.middlewarePorts.AdminAuthorization
+	body := h.middlewarePorts.LimitBody
+	h.route.GET("/api/v1/admin/coupons/bloom", admin(couponServicePorts.BloomInfo))
+	h.route.POST("/api/v1/admin/coupons", admin(body(couponAdminServicePorts.AddCoupons)))
+	h.route.POST("/api/v1/admin/coupons/upload", admin(couponAdminServicePorts.UploadCoupons))
+	h.route.GET("/api/v1/admin/coupons/revocations", admin(couponAdminServicePorts.ListRevocations))
+	h.route.GET("/api/v1/admin/coupons/{code}", admin(couponAdminServicePorts.CouponStatus))
+	h.route.DELETE("/api/v1/admin/coupons/{code}", admin(body(couponAdminServicePorts.RevokeCoupon)))
+	h.route.DELETE("/api/v1/admin/coupons/{code}/revocation", admin(couponAdminServicePorts.ReinstateCoupon))
 }
@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"errors"
+	"io"
+
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/ports"
@@ -46,11 +49,36 @@ func (m *middleware) Authorization(next fasthttp.RequestHandler) fasthttp.Reques
 				ctx.SetBodyString(`{"error": "Invalid API key"}`)
 				return
 			}
+
+			ctx.SetUserValue(constants.CtxClientID, utils.ClientID(apiKey))
+			ctx.SetUserValue(constants.CtxTrusted, m.config.ApiKey.TrustedApiKeys[apiKey])
 		}
 		next(ctx)
 	}
 }
 
+// AdminAuthorization only lets through requests carrying one of the admin API
+// keys.
+func (m *middleware) AdminAuthorization(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		apiKey, ok := utils.HeaderValue[string](ctx, constants.API_KEY.String())
+		if !ok || apiKey == "" {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			ctx.SetBodyString(`{"error": "API key missing"}`)
+			return
+		}
+
+		if _, allowed := m.config.AdminApiKey.AllowedApiKeys[apiKey]; !allowed {
+			ctx.SetStatusCode(fasthttp.StatusForbidden)
+			ctx.SetBodyString(`{"error": "Invalid admin API key"}`)
+			return
+		}
+
+		ctx.SetUserValue(constants.CtxClientID, utils.ClientID(apiKey))
+		next(ctx)
+	}
+}
+
 func (m *middleware) RequestId(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		requestId := uuid.NewString()
@@ -61,6 +89,29 @@ func (m *middleware) RequestId(next fasthttp.RequestHandler) fasthttp.RequestHan
 	}
 }
 
+// LimitBody buffers the request body, which the server hands over as a stream
+// so coupon uploads need not fit in memory, and rejects bodies larger than
+// app.server.maxRequestBodySize.
+func (m *middleware) LimitBody(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if ctx.Request.IsBodyStream() {
+			body, err := io.ReadAll(utils.LimitReader(ctx.RequestBodyStream(), int64(m.config.App.Server.BodyLimit())))
+			if errors.Is(err, utils.ErrBodyTooLarge) {
+				ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+				ctx.SetBodyString(`{"error": "request body too large"}`)
+				return
+			}
+			if err != nil {
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				ctx.SetBodyString(`{"error": "failed to read request body"}`)
+				return
+			}
+			ctx.Request.SetBody(body)
+		}
+		next(ctx)
+	}
+}
+
 func (m *middleware) PanicRecover(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		defer func() {
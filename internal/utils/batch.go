@@ -0,0 +1,35 @@
+package utils
+
+// Batch buffers items and flushes them in chunks of size.
+type Batch[T any] struct {
+	items []T
+	size  int
+	flush func(items []T) error
+}
+
+func NewBatch[T any](size int, flush func(items []T) error) *Batch[T] {
+	return &Batch[T]{
+		items: make([]T, 0, size),
+		size:  size,
+		flush: flush,
+	}
+}
+
+func (b *Batch[T]) Add(item T) error {
+	b.items = append(b.items, item)
+	if len(b.items) >= b.size {
+		return b.Flush()
+	}
+	return nil
+}
+
+func (b *Batch[T]) Flush() error {
+	if len(b.items) == 0 {
+		return nil
+	}
+	if err := b.flush(b.items); err != nil {
+		return err
+	}
+	b.items = b.items[:0]
+	return nil
+}
@@ -5,4 +5,19 @@ import "errors"
 var (
 	ErrDuplicateKey error = errors.New("document already exists")
 	ErrNoData       error = errors.New("data does not exists")
+
+	ErrCouponNotFound        error = errors.New("coupon code does not exist")
+	ErrCouponNotApplicable   error = errors.New("coupon code is not applicable to this order")
+	ErrCouponNotActive       error = errors.New("coupon code is not active yet")
+	ErrCouponExpired         error = errors.New("coupon code has expired")
+	ErrCouponExhausted       error = errors.New("coupon code redemption limit reached")
+	ErrCouponAlreadyRedeemed error = errors.New("coupon code already redeemed by this customer")
+	ErrCouponRevoked         error = errors.New("coupon code has been revoked")
+	ErrCouponNotEligible     error = errors.New("coupon code is not available to this customer")
+	ErrCouponNotStackable    error = errors.New("coupon code cannot be combined with the other codes")
+	ErrCouponsNotReady       error = errors.New("coupon service is not ready, retry later")
+
+	ErrBodyTooLarge error = errors.New("request body too large")
+
+	ErrOrderStatusConflict error = errors.New("order status changed concurrently, retry")
 )
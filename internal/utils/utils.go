@@ -1,12 +1,18 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +20,7 @@ import (
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/constants"
 	"github.com/bhupendra-dudhwal/kart-challenge/internal/core/models"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/valyala/fasthttp"
 )
 
@@ -60,6 +67,12 @@ func ctxValue[T any](v any) (T, bool) {
 			return zero, false
 		}
 		return any(strVal).(T), true
+	case bool:
+		boolVal, ok := v.(bool)
+		if !ok {
+			return zero, false
+		}
+		return any(boolVal).(T), true
 	case int64:
 		var strVal string
 		switch t := v.(type) {
@@ -119,39 +132,118 @@ var gzipReaderPool = sync.Pool{
 	New: func() any { return new(gzip.Reader) },
 }
 
-func Unzip(gzFile, sourceExt, destExt string) error {
-	destFile := strings.TrimSuffix(gzFile, sourceExt) + destExt
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
 
-	if _, err := os.Stat(destFile); err == nil {
-		return nil
+type decompressedFile struct {
+	io.Reader
+	closers []func() error
+}
+
+func (d *decompressedFile) Close() error {
+	var firstErr error
+	for _, closeFn := range d.closers {
+		if err := closeFn(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	tmp := destFile + ".tmp"
+// OpenDecompressed opens path and returns a reader over its decompressed
+// content. gzip, zstd and bzip2 inputs are detected by their magic bytes;
+// anything else is read as plain text.
+func OpenDecompressed(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open src: %w", err)
+	}
 
-	if err := unzipGZ(gzFile, tmp); err != nil {
-		_ = os.Remove(tmp)
-		return fmt.Errorf("failed to unzip %s: %w", gzFile, err)
+	rc, err := NewDecompressedReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d := rc.(*decompressedFile)
+	d.closers = append(d.closers, f.Close)
+	return d, nil
+}
+
+// NewDecompressedReader returns a reader over the decompressed content of r,
+// detecting the format like OpenDecompressed. Closing it does not close r.
+func NewDecompressedReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, 1*1024*1024)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("read magic bytes: %w", err)
 	}
 
-	return os.Rename(tmp, destFile)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz := gzipReaderPool.Get().(*gzip.Reader)
+		if err := gz.Reset(br); err != nil {
+			gzipReaderPool.Put(gz)
+			return nil, fmt.Errorf("gzip reset: %w", err)
+		}
+		return &decompressedFile{Reader: gz, closers: []func() error{
+			func() error {
+				err := gz.Close()
+				gzipReaderPool.Put(gz)
+				return err
+			},
+		}}, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("zstd reader: %w", err)
+		}
+		return &decompressedFile{Reader: zr, closers: []func() error{
+			func() error {
+				zr.Close()
+				return nil
+			},
+		}}, nil
+
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return &decompressedFile{Reader: bzip2.NewReader(br)}, nil
+
+	default:
+		return &decompressedFile{Reader: br}, nil
+	}
 }
 
-func unzipGZ(src, dst string) error {
-	f, err := os.Open(src)
+// DecompressToFile writes the decompressed content of src to dst, skipping the
+// work when dst already exists and is newer than src.
+func DecompressToFile(src, dst string) error {
+	srcInfo, err := os.Stat(src)
 	if err != nil {
-		return fmt.Errorf("open src: %w", err)
+		return fmt.Errorf("stat src: %w", err)
 	}
-	defer f.Close()
 
-	gz := gzipReaderPool.Get().(*gzip.Reader)
-	if err := gz.Reset(f); err != nil {
-		gzipReaderPool.Put(gz)
-		return fmt.Errorf("gzip reset: %w", err)
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.ModTime().After(srcInfo.ModTime()) {
+		return nil
 	}
-	defer func() {
-		gz.Close()
-		gzipReaderPool.Put(gz)
-	}()
+
+	tmp := dst + ".tmp"
+
+	if err := decompressFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to decompress %s: %w", src, err)
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func decompressFile(src, dst string) error {
+	in, err := OpenDecompressed(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
 	out, err := os.Create(dst)
 	if err != nil {
@@ -161,8 +253,30 @@ func unzipGZ(src, dst string) error {
 
 	buf := make([]byte, 1*1024*1024)
 
-	_, err = io.CopyBuffer(out, gz, buf)
-	return err
+	if _, err := io.CopyBuffer(out, in, buf); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// LimitReader reads at most n bytes of r. Unlike io.LimitReader it fails with
+// ErrBodyTooLarge when r holds more, instead of silently stopping at n.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{reader: io.LimitReader(r, n+1), left: n}
+}
+
+type limitedReader struct {
+	reader io.Reader
+	left   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n + int(l.left), ErrBodyTooLarge
+	}
+	return n, err
 }
 
 func Max(a, b int) int {
@@ -176,49 +290,152 @@ func isUpper(ch byte) bool { return ch >= 'A' && ch <= 'Z' }
 func isLower(ch byte) bool { return ch >= 'a' && ch <= 'z' }
 func isDigit(ch byte) bool { return ch >= '0' && ch <= '9' }
 
+// NormalizeCode brings code to the canonical form it is validated, stored
+// and looked up in: trimmed, without separators, and case folded per cfg.
+func NormalizeCode(code string, cfg *models.CouponValidator) string {
+	code = strings.TrimSpace(code)
+	if cfg.Separators != "" {
+		code = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(cfg.Separators, r) {
+				return -1
+			}
+			return r
+		}, code)
+	}
+
+	switch cfg.Case {
+	case constants.CaseUpper:
+		code = strings.ToUpper(code)
+	case constants.CaseLower:
+		code = strings.ToLower(code)
+	}
+	return code
+}
+
+// ValidateCode reports whether the normalised code satisfies cfg.
 func ValidateCode(code string, cfg *models.CouponValidator) bool {
+	return CheckCode(code, cfg) == ""
+}
+
+// CheckCode returns why the normalised code does not satisfy cfg, or an empty
+// reason when it does.
+func CheckCode(code string, cfg *models.CouponValidator) constants.CodeRejection {
 	ln := len(code)
 
+	if ln == 0 {
+		return constants.RejectEmpty
+	}
 	if ln < cfg.MinLength || ln > cfg.MaxLength {
-		return false
+		return constants.RejectLength
 	}
 
-	for i := 0; i < ln; i++ {
-		ch := code[i]
+	if cfg.AllowedCharacters != "" {
+		for i := 0; i < ln; i++ {
+			ch := code[i]
 
-		switch cfg.AllowedCharacters {
+			switch cfg.AllowedCharacters {
 
-		case constants.Alphanumeric:
-			if !(isUpper(ch) || isLower(ch) || isDigit(ch)) {
-				return false
-			}
+			case constants.Alphanumeric:
+				if !(isUpper(ch) || isLower(ch) || isDigit(ch)) {
+					return constants.RejectCharacters
+				}
 
-		case constants.Digits:
-			if !isDigit(ch) {
-				return false
-			}
+			case constants.Digits:
+				if !isDigit(ch) {
+					return constants.RejectCharacters
+				}
 
-		case constants.Letters:
-			if !(isUpper(ch) || isLower(ch)) {
-				return false
-			}
+			case constants.Letters:
+				if !(isUpper(ch) || isLower(ch)) {
+					return constants.RejectCharacters
+				}
 
-		case constants.Uppercase:
-			if !isUpper(ch) {
-				return false
-			}
+			case constants.Uppercase:
+				if !isUpper(ch) {
+					return constants.RejectCharacters
+				}
+
+			case constants.Lowercase:
+				if !isLower(ch) {
+					return constants.RejectCharacters
+				}
 
-		case constants.Lowercase:
-			if !isLower(ch) {
-				return false
+			default:
+				return constants.RejectCharacters
 			}
+		}
+	}
 
-		default:
-			return false
+	if cfg.Pattern != "" || cfg.Format != "" {
+		pattern, err := codePattern(cfg)
+		if err != nil || !pattern.MatchString(code) {
+			return constants.RejectPattern
 		}
 	}
 
-	return true
+	switch cfg.Checksum {
+	case constants.ChecksumLuhn:
+		if !luhnModN(code, luhnAlphabet) {
+			return constants.RejectChecksum
+		}
+	case constants.ChecksumMod36:
+		if !luhnModN(strings.ToUpper(code), mod36Alphabet) {
+			return constants.RejectChecksum
+		}
+	}
+
+	return ""
+}
+
+// codePatterns caches the compiled pattern of each validator pattern and
+// format, since every coupon line and order is matched against it.
+var codePatterns sync.Map
+
+func codePattern(cfg *models.CouponValidator) (*regexp.Regexp, error) {
+	key := cfg.Pattern + "\x00" + cfg.Format
+	if pattern, ok := codePatterns.Load(key); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+
+	expr, err := cfg.Expression()
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	codePatterns.Store(key, pattern)
+	return pattern, nil
+}
+
+const (
+	luhnAlphabet  = "0123456789"
+	mod36Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// luhnModN checks the trailing check character of code with the Luhn mod N
+// algorithm, N being the size of alphabet; plain Luhn is the decimal case.
+// Characters outside alphabet, such as a letter prefix for Luhn or literal
+// dashes, are not covered by the checksum and skipped.
+func luhnModN(code, alphabet string) bool {
+	n := len(alphabet)
+	sum, covered := 0, 0
+	double := false
+	for i := len(code) - 1; i >= 0; i-- {
+		v := strings.IndexByte(alphabet, code[i])
+		if v < 0 {
+			continue
+		}
+		if double {
+			v *= 2
+			v = v/n + v%n
+		}
+		sum += v
+		covered++
+		double = !double
+	}
+	return covered >= 2 && sum%n == 0
 }
 
 func Sanitize(str string) string {
@@ -230,3 +447,17 @@ func RoundFloat64(value float64, precision int) float64 {
 	factor := math.Pow(10, float64(precision))
 	return math.Round(value*factor) / factor
 }
+
+// ClientID derives a stable, non-reversible identifier for an API key.
+func ClientID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+var customerIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:@-]{1,64}$`)
+
+// ValidateCustomerID reports whether id is a well-formed customer ID: 1 to 64
+// letters, digits or any of ._:@-
+func ValidateCustomerID(id string) bool {
+	return customerIDPattern.MatchString(id)
+}
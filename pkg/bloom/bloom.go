@@ -0,0 +1,158 @@
+// Package bloom is an in-process Bloom filter, used for coupon lookups when the
+// RedisBloom module is not available.
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+var magic = [4]byte{'K', 'B', 'F', '1'}
+
+const headerSize = len(magic) + 8 + 4 + 8
+
+var ErrInvalidData = errors.New("bloom: invalid serialized filter")
+
+// Filter is a Bloom filter safe for concurrent use.
+type Filter struct {
+	mu    sync.RWMutex
+	bits  []uint64
+	m     uint64
+	k     uint32
+	count uint64
+}
+
+// New returns a filter sized for capacity items at the given false-positive
+// rate.
+func New(capacity uint64, errorRate float64) *Filter {
+	m, k := EstimateParameters(capacity, errorRate)
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// EstimateParameters returns the number of bits and hash functions a filter
+// needs to hold capacity items at the given false-positive rate.
+func EstimateParameters(capacity uint64, errorRate float64) (uint64, uint32) {
+	if capacity == 0 {
+		capacity = 1
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		errorRate = 0.01
+	}
+
+	n := float64(capacity)
+	m := math.Ceil(-n * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / n * math.Ln2)
+	return uint64(math.Max(m, 64)), uint32(math.Max(k, 1))
+}
+
+// Add inserts item and reports whether it was possibly present already.
+func (f *Filter) Add(item string) bool {
+	h1, h2 := hashes(item)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	present := true
+	for i := uint32(0); i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		word, bit := idx/64, uint64(1)<<(idx%64)
+		if f.bits[word]&bit == 0 {
+			present = false
+			f.bits[word] |= bit
+		}
+	}
+	if !present {
+		f.count++
+	}
+	return present
+}
+
+// Test reports whether item is possibly in the filter.
+func (f *Filter) Test(item string) bool {
+	h1, h2 := hashes(item)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for i := uint32(0); i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		if f.bits[idx/64]&(uint64(1)<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the approximate number of distinct items added.
+func (f *Filter) Count() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.count
+}
+
+// Capacity returns the number of items the filter was sized for.
+func (f *Filter) Capacity() uint64 {
+	return uint64(float64(f.m) * math.Ln2 / float64(f.k))
+}
+
+// Size returns the size of the filter in bytes.
+func (f *Filter) Size() int {
+	return len(f.bits) * 8
+}
+
+// MarshalBinary encodes the filter.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	buf := make([]byte, headerSize+len(f.bits)*8)
+	copy(buf, magic[:])
+	binary.LittleEndian.PutUint64(buf[4:], f.m)
+	binary.LittleEndian.PutUint32(buf[12:], f.k)
+	binary.LittleEndian.PutUint64(buf[16:], f.count)
+	for i, word := range f.bits {
+		binary.LittleEndian.PutUint64(buf[headerSize+i*8:], word)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize || [4]byte(data[:4]) != magic {
+		return ErrInvalidData
+	}
+
+	m := binary.LittleEndian.Uint64(data[4:])
+	k := binary.LittleEndian.Uint32(data[12:])
+	count := binary.LittleEndian.Uint64(data[16:])
+	words := (m + 63) / 64
+	if m == 0 || k == 0 || uint64(len(data)-headerSize) != words*8 {
+		return ErrInvalidData
+	}
+
+	bits := make([]uint64, words)
+	for i := range bits {
+		bits[i] = binary.LittleEndian.Uint64(data[headerSize+i*8:])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bits, f.m, f.k, f.count = bits, m, k, count
+	return nil
+}
+
+// hashes derives the two base hashes combined into the k probe positions.
+func hashes(item string) (uint64, uint64) {
+	a := fnv.New64a()
+	a.Write([]byte(item))
+	b := fnv.New64()
+	b.Write([]byte(item))
+	return a.Sum64(), b.Sum64() | 1
+}
@@ -0,0 +1,105 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFilterRoundTrip(t *testing.T) {
+	filter := New(1000, 0.01)
+	for i := range 500 {
+		filter.Add(fmt.Sprintf("CODE%05d", i))
+	}
+
+	raw, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Filter
+	if err := decoded.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != filter.Count() {
+		t.Errorf("count = %d, want %d", decoded.Count(), filter.Count())
+	}
+	if decoded.Capacity() != filter.Capacity() || decoded.Size() != filter.Size() {
+		t.Errorf("capacity, size = %d, %d, want %d, %d", decoded.Capacity(), decoded.Size(), filter.Capacity(), filter.Size())
+	}
+	for i := range 1000 {
+		code := fmt.Sprintf("CODE%05d", i)
+		if decoded.Test(code) != filter.Test(code) {
+			t.Fatalf("Test(%s) differs after a round trip", code)
+		}
+	}
+
+	again, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(raw) {
+		t.Error("encoding a decoded filter does not give the same bytes")
+	}
+}
+
+func TestFilterUnmarshalInvalid(t *testing.T) {
+	raw, err := New(100, 0.01).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badMagic := append([]byte(nil), raw...)
+	badMagic[0] = 'X'
+	noHashes := append([]byte(nil), raw...)
+	clear(noHashes[12:16])
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "short header", data: raw[:headerSize-1]},
+		{name: "wrong magic", data: badMagic},
+		{name: "truncated bits", data: raw[:len(raw)-8]},
+		{name: "trailing bytes", data: append(append([]byte(nil), raw...), 0)},
+		{name: "no hash functions", data: noHashes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter Filter
+			if err := filter.UnmarshalBinary(tt.data); !errors.Is(err, ErrInvalidData) {
+				t.Errorf("UnmarshalBinary() = %v, want %v", err, ErrInvalidData)
+			}
+		})
+	}
+}
+
+func TestFilterFalsePositiveRate(t *testing.T) {
+	const (
+		capacity  = 20000
+		errorRate = 0.01
+		probes    = 200000
+	)
+
+	filter := New(capacity, errorRate)
+	for i := range capacity {
+		code := fmt.Sprintf("IN%08d", i)
+		filter.Add(code)
+		if !filter.Test(code) {
+			t.Fatalf("Test(%s) = false right after Add", code)
+		}
+	}
+
+	var falsePositives int
+	for i := range probes {
+		if filter.Test(fmt.Sprintf("OUT%08d", i)) {
+			falsePositives++
+		}
+	}
+	// The rate is only an expectation; twice the target leaves room for
+	// chance without letting a broken hash through.
+	if rate := float64(falsePositives) / probes; rate > 2*errorRate {
+		t.Errorf("false-positive rate = %.4f at capacity, want at most %.4f", rate, 2*errorRate)
+	}
+}